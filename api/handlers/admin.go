@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usman-007/checkbox-backend/internal/services"
+	"github.com/usman-007/checkbox-backend/internal/store"
+)
+
+// AdminHandler handles requests to the admin API
+type AdminHandler struct {
+	gridService *services.GridService
+}
+
+// NewAdminHandler creates a new instance of AdminHandler
+func NewAdminHandler(gridService *services.GridService) *AdminHandler {
+	return &AdminHandler{
+		gridService: gridService,
+	}
+}
+
+// ResetGrid handles POST requests clearing every checkbox of a grid. Only the
+// grid's own state is removed, and its WebSocket clients on every instance
+// are sent a fresh snapshot.
+func (h *AdminHandler) ResetGrid(c *gin.Context) {
+	checkboxService, ok := resolveGrid(c, h.gridService)
+	if !ok {
+		return
+	}
+
+	version, err := checkboxService.ResetGrid(c.Request.Context())
+	if errors.Is(err, store.ErrGridNotFound) {
+		writeError(c, http.StatusNotFound, codeGridNotFound, "Grid was not reset: "+err.Error())
+		return
+	}
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, codeInternal, "Failed to reset grid: "+err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Grid reset successfully",
+		"data": gin.H{
+			"id":      checkboxService.ID,
+			"version": version,
+		},
+	})
+}
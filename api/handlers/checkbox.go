@@ -1,87 +1,151 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/usman-007/checkbox-backend/api/middleware"
+	"github.com/usman-007/checkbox-backend/config"
+	"github.com/usman-007/checkbox-backend/internal/models"
 	"github.com/usman-007/checkbox-backend/internal/monitoring"
+	"github.com/usman-007/checkbox-backend/internal/ratelimit"
 	"github.com/usman-007/checkbox-backend/internal/services"
+	"github.com/usman-007/checkbox-backend/internal/store"
+)
+
+// GridVersionHeader carries the grid version a response reflects
+const GridVersionHeader = "X-Grid-Version"
+
+// Response formats for GET requests, chosen with the format query parameter
+const (
+	// formatJSON is a map keyed by "states:(r,c)"
+	formatJSON = "json"
+	// formatBits is the region as a base64 bitmap, like a WebSocket snapshot
+	formatBits = "bits"
 )
 
 // CheckboxHandler handles HTTP requests related to checkboxes
 type CheckboxHandler struct {
-	checkboxService *services.CheckboxService
+	gridService *services.GridService
+	cfg         config.GridConfig
+	// limiter charges batches one token per operation
+	limiter ratelimit.Limiter
+	// maxBatch is the most operations a batch may hold: no more than the
+	// service accepts, nor than the limiter could ever allow
+	maxBatch int
 }
 
 // NewCheckboxHandler creates a new instance of CheckboxHandler
-func NewCheckboxHandler(checkboxService *services.CheckboxService) *CheckboxHandler {
+func NewCheckboxHandler(gridService *services.GridService, cfg config.GridConfig, limiter ratelimit.Limiter) *CheckboxHandler {
 	return &CheckboxHandler{
-		checkboxService: checkboxService,
+		gridService: gridService,
+		cfg:         cfg,
+		limiter:     limiter,
+		maxBatch:    min(services.MaxBatchSize, limiter.Burst()),
 	}
 }
 
-// GetAllCheckboxes handles GET requests to get all checkboxes
+// GetAllCheckboxes handles GET requests to get the checkboxes of the whole
+// grid, or of the region selected by the row_start, row_end, col_start and
+// col_end query parameters. format=bits returns the region as a bitmap
+// instead of a map.
 func (h *CheckboxHandler) GetAllCheckboxes(c *gin.Context) {
-	checkboxes, err := h.checkboxService.GetAllCheckboxes()
+	checkboxService, ok := resolveGrid(c, h.gridService)
+	if !ok {
+		return
+	}
+	region, ok := parseRegion(c, checkboxService)
+	if !ok {
+		return
+	}
+	if cells := region.Rows() * region.Cols(); cells > h.cfg.MaxRegionCells {
+		writeError(c, http.StatusBadRequest, codeRegionTooLarge,
+			fmt.Sprintf("Region of %d cells is too large: at most %d cells may be requested at once", cells, h.cfg.MaxRegionCells))
+		return
+	}
+	format := c.DefaultQuery("format", formatJSON)
+	if format != formatJSON && format != formatBits {
+		writeError(c, http.StatusBadRequest, codeInvalidRequest,
+			fmt.Sprintf("Invalid format parameter: must be '%s' or '%s'", formatJSON, formatBits))
+		return
+	}
+
+	snapshot, err := checkboxService.RegionSnapshot(c.Request.Context(), region)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to get checkboxes: " + err.Error(),
-		})
+		writeError(c, http.StatusInternalServerError, codeInternal, "Failed to get checkboxes: "+err.Error())
+		return
+	}
+	// Neither body carries the version; it travels in a header
+	c.Header(GridVersionHeader, strconv.FormatInt(snapshot.Version, 10))
+	if format == formatBits {
+		grid := checkboxService.GetGrid()
+		c.JSON(http.StatusOK, snapshotPayload{Rows: grid.Rows, Cols: grid.Cols, Bitmap: snapshot.Bitmap, Region: region})
 		return
 	}
-	c.JSON(http.StatusOK, checkboxes)
+	c.JSON(http.StatusOK, services.CheckboxMap(region, snapshot.Bitmap))
 }
 
-// UpdateCheckbox handles PATCH requests to update checkbox state
-func (h *CheckboxHandler) UpdateCheckbox(c *gin.Context) {
-	// Extract query parameters
-	rowStr := c.Query("row")
-	columnStr := c.Query("column")
-	valueStr := c.Query("value")
+// GetCheckbox handles GET requests for the checkbox at the :row and :col
+// route parameters, including when and by whom it was last changed if the
+// grid keeps cell metadata
+func (h *CheckboxHandler) GetCheckbox(c *gin.Context) {
+	checkboxService, ok := resolveGrid(c, h.gridService)
+	if !ok {
+		return
+	}
 
-	// Validate parameters
-	if rowStr == "" || columnStr == "" || valueStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Missing required parameters: row, column, and value are required",
-		})
+	row, rowErr := strconv.Atoi(c.Param("row"))
+	column, colErr := strconv.Atoi(c.Param("col"))
+	if rowErr != nil || colErr != nil {
+		writeError(c, http.StatusBadRequest, codeInvalidRequest, "Invalid cell: row and column must be integers")
+		return
+	}
+	if code, message := checkboxError(checkboxService, models.Checkbox{Row: &row, Column: &column}, false); code != "" {
+		writeError(c, http.StatusBadRequest, code, message)
 		return
 	}
 
-	// Convert row and column to integers
-	row, err := strconv.Atoi(rowStr)
+	checkbox, err := checkboxService.GetCheckbox(c.Request.Context(), row, column)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid row parameter: must be an integer",
-		})
+		writeError(c, http.StatusInternalServerError, codeInternal, "Failed to get checkbox: "+err.Error())
 		return
 	}
+	c.JSON(http.StatusOK, checkbox)
+}
 
-	column, err := strconv.Atoi(columnStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid column parameter: must be an integer",
-		})
+// UpdateCheckbox handles PATCH requests to update checkbox state. The row,
+// column and value come from a JSON body, or from query parameters for
+// requests without one. With the optional expected field the update only
+// applies if the checkbox holds that value, and a mismatch is answered with
+// 409 and the current value.
+func (h *CheckboxHandler) UpdateCheckbox(c *gin.Context) {
+	checkboxService, ok := resolveGrid(c, h.gridService)
+	if !ok {
 		return
 	}
 
-	// Parse boolean value
-	value := false
-	if valueStr == "true" {
-		value = true
-	} else if valueStr != "false" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid value parameter: must be 'true' or 'false'",
-		})
+	var req models.CheckboxUpdate
+	if err := bindCheckboxRequest(c, &req); err != nil {
+		writeError(c, http.StatusBadRequest, codeInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+	if code, message := checkboxError(checkboxService, req.Checkbox, true); code != "" {
+		writeError(c, http.StatusBadRequest, code, message)
 		return
 	}
 
-	// Call service to update the checkbox state in Redis
-	err = h.checkboxService.UpdateCheckboxState(uint32(row), uint32(column), value)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to update checkbox state: " + err.Error(),
-		})
+	ctx := c.Request.Context()
+	var change store.Change
+	var err error
+	if req.Expected != nil {
+		change, err = checkboxService.CompareAndSetCheckbox(ctx, *req.Row, *req.Column, *req.Expected, *req.Value)
+	} else {
+		change, err = checkboxService.UpdateCheckboxState(ctx, *req.Row, *req.Column, *req.Value)
+	}
+	if !writeCellError(c, change, err) {
 		return
 	}
 
@@ -90,10 +154,188 @@ func (h *CheckboxHandler) UpdateCheckbox(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Checkbox state updated successfully",
+		"data":    cellData(change),
+	})
+}
+
+// ToggleCheckbox handles POST requests flipping the checkbox at the row and
+// column of a JSON body or query parameters, answering with its new value
+func (h *CheckboxHandler) ToggleCheckbox(c *gin.Context) {
+	checkboxService, ok := resolveGrid(c, h.gridService)
+	if !ok {
+		return
+	}
+
+	var req models.Checkbox
+	if err := bindCheckboxRequest(c, &req); err != nil {
+		writeError(c, http.StatusBadRequest, codeInvalidRequest, "Invalid request: "+err.Error())
+		return
+	}
+	if code, message := checkboxError(checkboxService, req, false); code != "" {
+		writeError(c, http.StatusBadRequest, code, message)
+		return
+	}
+
+	change, err := checkboxService.ToggleCheckbox(c.Request.Context(), *req.Row, *req.Column)
+	if !writeCellError(c, change, err) {
+		return
+	}
+
+	// Record metrics for successful grid state update
+	monitoring.GridStateUpdates.Inc()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Checkbox toggled successfully",
+		"data":    cellData(change),
+	})
+}
+
+// bindCheckboxRequest binds a single-cell request from its JSON body, whatever
+// its Content-Type, or from the query parameters when it has no body
+func bindCheckboxRequest(c *gin.Context, req any) error {
+	if c.Request.ContentLength == 0 {
+		return c.ShouldBindQuery(req)
+	}
+	return c.ShouldBindJSON(req)
+}
+
+// checkboxError checks that checkbox names a cell inside the grid and, if
+// needValue, carries a value. It returns the code and message of the first
+// problem found, or empty strings if there is none.
+func checkboxError(checkboxService *services.CheckboxService, checkbox models.Checkbox, needValue bool) (code, message string) {
+	switch {
+	case checkbox.Row == nil || checkbox.Column == nil:
+		return codeInvalidRequest, "Missing required fields: row and column are required"
+	case needValue && checkbox.Value == nil:
+		return codeInvalidRequest, "Missing required field: value is required"
+	case *checkbox.Row < 0 || *checkbox.Column < 0:
+		return codeOutOfRange, fmt.Sprintf("Cell (%d,%d) is out of range: row and column must not be negative", *checkbox.Row, *checkbox.Column)
+	case !checkboxService.InBounds(*checkbox.Row, *checkbox.Column):
+		grid := checkboxService.GetGrid()
+		return codeOutOfRange, fmt.Sprintf("Cell (%d,%d) is out of range: grid is %d rows by %d columns", *checkbox.Row, *checkbox.Column, grid.Rows, grid.Cols)
+	}
+	return "", ""
+}
+
+// writeCellError answers a failed single-cell update and reports whether err
+// was nil. A value mismatch is a 409 carrying the cell's current value.
+func writeCellError(c *gin.Context, change store.Change, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrValueMismatch):
+		body := errorBody(codeValueMismatch, "Checkbox was not updated: "+err.Error())
+		body["data"] = cellData(change)
+		c.JSON(http.StatusConflict, body)
+	case errors.Is(err, services.ErrCellOutOfRange):
+		writeError(c, http.StatusBadRequest, codeOutOfRange, "Invalid cell: "+err.Error())
+	case errors.Is(err, store.ErrGridNotFound):
+		writeError(c, http.StatusNotFound, codeGridNotFound, "Checkbox was not updated: "+err.Error())
+	default:
+		writeError(c, http.StatusInternalServerError, codeInternal, "Failed to update checkbox state: "+err.Error())
+	}
+	return false
+}
+
+// cellData is the data returned for a single-cell update
+func cellData(change store.Change) gin.H {
+	return gin.H{
+		"row":     change.Row,
+		"column":  change.Col,
+		"value":   change.Value,
+		"version": change.Version,
+	}
+}
+
+// Outcomes of a batch operation
+const (
+	batchApplied = "applied"
+	batchInvalid = "invalid"
+	// batchSkipped marks a valid operation left out because another was invalid
+	batchSkipped = "skipped"
+)
+
+// batchResult reports what happened to one batch operation
+type batchResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	models.Checkbox
+	Version int64  `json:"version,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// UpdateCheckboxes handles POST requests applying a JSON list of
+// {row, column, value} updates. The updates are applied atomically, so if
+// any is invalid none are; the response reports each one's outcome. Each
+// update counts against the client's rate limit.
+func (h *CheckboxHandler) UpdateCheckboxes(c *gin.Context) {
+	checkboxService, ok := resolveGrid(c, h.gridService)
+	if !ok {
+		return
+	}
+
+	var operations []models.Checkbox
+	if err := c.ShouldBindJSON(&operations); err != nil {
+		writeError(c, http.StatusBadRequest, codeInvalidRequest,
+			"Invalid request body: expected a list of {row, column, value} operations: "+err.Error())
+		return
+	}
+	if len(operations) == 0 || len(operations) > h.maxBatch {
+		writeError(c, http.StatusBadRequest, codeInvalidBatch,
+			fmt.Sprintf("A batch must hold between 1 and %d operations, got %d", h.maxBatch, len(operations)))
+		return
+	}
+
+	// Validate every operation before applying any
+	results := make([]batchResult, len(operations))
+	updates := make([]store.CellUpdate, len(operations))
+	invalid := false
+	for i, op := range operations {
+		results[i] = batchResult{Index: i, Status: batchSkipped, Checkbox: op}
+		if code, message := checkboxError(checkboxService, op, true); code != "" {
+			results[i].Status = batchInvalid
+			results[i].Code = code
+			results[i].Error = message
+			invalid = true
+			continue
+		}
+		updates[i] = store.CellUpdate{Row: *op.Row, Col: *op.Column, Value: *op.Value}
+	}
+	if invalid {
+		body := errorBody(codeInvalidBatch, "Batch rejected: some operations are invalid, so none were applied")
+		body["results"] = results
+		c.JSON(http.StatusBadRequest, body)
+		return
+	}
+
+	// Every operation counts against the client's rate limit
+	if !middleware.TakeTokens(c, h.limiter, len(updates)) {
+		return
+	}
+
+	changes, err := checkboxService.UpdateCheckboxStates(c.Request.Context(), updates)
+	if errors.Is(err, store.ErrGridNotFound) {
+		writeError(c, http.StatusNotFound, codeGridNotFound, "Checkbox states were not updated: "+err.Error())
+		return
+	}
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, codeInternal, "Failed to update checkbox states: "+err.Error())
+		return
+	}
+
+	// Record metrics for successful grid state updates
+	monitoring.GridStateUpdates.Add(float64(len(changes)))
+
+	for i, change := range changes {
+		results[i].Status = batchApplied
+		results[i].Version = change.Version
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Checkbox states updated successfully",
 		"data": gin.H{
-			"row":    row,
-			"column": column,
-			"value":  value,
+			"version": changes[len(changes)-1].Version,
+			"results": results,
 		},
 	})
 }
@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+// batchStatuses returns the status and code of each result of a batch response
+func batchStatuses(body map[string]any) (statuses, codes []string) {
+	results, _ := body["results"].([]any)
+	if data, ok := body["data"].(map[string]any); ok {
+		results, _ = data["results"].([]any)
+	}
+	for _, r := range results {
+		result, _ := r.(map[string]any)
+		status, _ := result["status"].(string)
+		code, _ := result["code"].(string)
+		statuses = append(statuses, status)
+		codes = append(codes, code)
+	}
+	return statuses, codes
+}
+
+func TestUpdateCheckboxes(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantStatus   int
+		wantCode     string
+		wantStatuses []string
+		wantCodes    []string
+		// wantSet are the cells set afterwards, as {row, col}
+		wantSet [][2]int
+	}{
+		{
+			name:         "all applied",
+			body:         `[{"row":0,"column":0,"value":true},{"row":3,"column":4,"value":true},{"row":1,"column":2,"value":false}]`,
+			wantStatus:   http.StatusOK,
+			wantStatuses: []string{batchApplied, batchApplied, batchApplied},
+			wantCodes:    []string{"", "", ""},
+			wantSet:      [][2]int{{0, 0}, {3, 4}},
+		},
+		{
+			name:         "out of range cell rejects the whole batch",
+			body:         `[{"row":0,"column":0,"value":true},{"row":4,"column":0,"value":true},{"row":1,"column":1,"value":true}]`,
+			wantStatus:   http.StatusBadRequest,
+			wantCode:     codeInvalidBatch,
+			wantStatuses: []string{batchSkipped, batchInvalid, batchSkipped},
+			wantCodes:    []string{"", codeOutOfRange, ""},
+		},
+		{
+			name:         "negative cell",
+			body:         `[{"row":-1,"column":0,"value":true},{"row":1,"column":1,"value":true}]`,
+			wantStatus:   http.StatusBadRequest,
+			wantCode:     codeInvalidBatch,
+			wantStatuses: []string{batchInvalid, batchSkipped},
+			wantCodes:    []string{codeOutOfRange, ""},
+		},
+		{
+			name:         "every invalid operation reported",
+			body:         `[{"row":0,"column":0},{"row":0,"column":9,"value":true},{"column":1,"value":true}]`,
+			wantStatus:   http.StatusBadRequest,
+			wantCode:     codeInvalidBatch,
+			wantStatuses: []string{batchInvalid, batchInvalid, batchInvalid},
+			wantCodes:    []string{codeInvalidRequest, codeOutOfRange, codeInvalidRequest},
+		},
+		{
+			name:       "empty batch",
+			body:       `[]`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   codeInvalidBatch,
+		},
+		{
+			name:       "more operations than the burst",
+			body:       `[{"row":0,"column":0,"value":true},{"row":0,"column":1,"value":true},{"row":0,"column":2,"value":true},{"row":0,"column":3,"value":true},{"row":0,"column":4,"value":true},{"row":1,"column":0,"value":true}]`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   codeInvalidBatch,
+		},
+		{
+			name:       "not a list",
+			body:       `{"row":0,"column":0,"value":true}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   codeInvalidRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, checkboxService := newTestRouter(t)
+			status, body := serve(t, router, http.MethodPost, "/checkbox/batch", "application/json", tt.body)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			if code, _ := body["code"].(string); code != tt.wantCode {
+				t.Errorf("code = %q, want %q", code, tt.wantCode)
+			}
+
+			statuses, codes := batchStatuses(body)
+			if len(statuses) != len(tt.wantStatuses) {
+				t.Fatalf("results statuses = %v, want %v", statuses, tt.wantStatuses)
+			}
+			for i := range statuses {
+				if statuses[i] != tt.wantStatuses[i] || codes[i] != tt.wantCodes[i] {
+					t.Errorf("result %d = %s/%q, want %s/%q", i, statuses[i], codes[i], tt.wantStatuses[i], tt.wantCodes[i])
+				}
+			}
+
+			// Batches are all or nothing
+			set := make(map[[2]int]bool)
+			for _, cell := range tt.wantSet {
+				set[cell] = true
+			}
+			for row := 0; row < testRows; row++ {
+				for col := 0; col < testCols; col++ {
+					if got := cellValue(t, checkboxService, row, col); got != set[[2]int{row, col}] {
+						t.Errorf("cell (%d,%d) = %v, want %v", row, col, got, set[[2]int{row, col}])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSingleCellRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		wantStatus  int
+		wantCode    string
+		// wantSet is the cell set afterwards, if any
+		wantSet *[2]int
+	}{
+		{
+			name:        "update from JSON body",
+			method:      http.MethodPatch,
+			target:      "/checkbox",
+			contentType: "application/json",
+			body:        `{"row":1,"column":2,"value":true}`,
+			wantStatus:  http.StatusOK,
+			wantSet:     &[2]int{1, 2},
+		},
+		{
+			name:       "update from JSON body without Content-Type",
+			method:     http.MethodPatch,
+			target:     "/checkbox",
+			body:       `{"row":1,"column":2,"value":true}`,
+			wantStatus: http.StatusOK,
+			wantSet:    &[2]int{1, 2},
+		},
+		{
+			name:        "update from JSON body sent as text",
+			method:      http.MethodPatch,
+			target:      "/checkbox",
+			contentType: "text/plain",
+			body:        `{"row":1,"column":2,"value":true}`,
+			wantStatus:  http.StatusOK,
+			wantSet:     &[2]int{1, 2},
+		},
+		{
+			name:       "update falls back to query without a body",
+			method:     http.MethodPatch,
+			target:     "/checkbox?row=3&column=4&value=true",
+			wantStatus: http.StatusOK,
+			wantSet:    &[2]int{3, 4},
+		},
+		{
+			name:        "body wins over query",
+			method:      http.MethodPatch,
+			target:      "/checkbox?row=3&column=4&value=true",
+			contentType: "application/json",
+			body:        `{"row":1,"column":2,"value":true}`,
+			wantStatus:  http.StatusOK,
+			wantSet:     &[2]int{1, 2},
+		},
+		{
+			name:        "update without value",
+			method:      http.MethodPatch,
+			target:      "/checkbox",
+			contentType: "application/json",
+			body:        `{"row":1,"column":2}`,
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    codeInvalidRequest,
+		},
+		{
+			name:        "update with malformed body",
+			method:      http.MethodPatch,
+			target:      "/checkbox",
+			contentType: "application/json",
+			body:        `{"row":1,`,
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    codeInvalidRequest,
+		},
+		{
+			name:        "update with negative row",
+			method:      http.MethodPatch,
+			target:      "/checkbox",
+			contentType: "application/json",
+			body:        `{"row":-1,"column":2,"value":true}`,
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    codeOutOfRange,
+		},
+		{
+			name:       "update with negative column in query",
+			method:     http.MethodPatch,
+			target:     "/checkbox?row=1&column=-2&value=true",
+			wantStatus: http.StatusBadRequest,
+			wantCode:   codeOutOfRange,
+		},
+		{
+			name:        "update past the last row",
+			method:      http.MethodPatch,
+			target:      "/checkbox",
+			contentType: "application/json",
+			body:        `{"row":4,"column":0,"value":true}`,
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    codeOutOfRange,
+		},
+		{
+			name:        "update past the last column",
+			method:      http.MethodPatch,
+			target:      "/checkbox",
+			contentType: "application/json",
+			body:        `{"row":0,"column":5,"value":true}`,
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    codeOutOfRange,
+		},
+		{
+			name:        "update expecting the wrong value",
+			method:      http.MethodPatch,
+			target:      "/checkbox",
+			contentType: "application/json",
+			body:        `{"row":1,"column":2,"value":false,"expected":true}`,
+			wantStatus:  http.StatusConflict,
+			wantCode:    codeValueMismatch,
+		},
+		{
+			name:       "toggle from query",
+			method:     http.MethodPost,
+			target:     "/checkbox/toggle?row=2&column=3",
+			wantStatus: http.StatusOK,
+			wantSet:    &[2]int{2, 3},
+		},
+		{
+			name:       "toggle from JSON body without Content-Type",
+			method:     http.MethodPost,
+			target:     "/checkbox/toggle",
+			body:       `{"row":2,"column":3}`,
+			wantStatus: http.StatusOK,
+			wantSet:    &[2]int{2, 3},
+		},
+		{
+			name:       "toggle past the grid",
+			method:     http.MethodPost,
+			target:     "/checkbox/toggle?row=9&column=9",
+			wantStatus: http.StatusBadRequest,
+			wantCode:   codeOutOfRange,
+		},
+		{
+			name:       "get cell",
+			method:     http.MethodGet,
+			target:     "/checkbox/3/4",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "get negative cell",
+			method:     http.MethodGet,
+			target:     "/checkbox/-1/0",
+			wantStatus: http.StatusBadRequest,
+			wantCode:   codeOutOfRange,
+		},
+		{
+			name:       "get cell past the grid",
+			method:     http.MethodGet,
+			target:     "/checkbox/0/5",
+			wantStatus: http.StatusBadRequest,
+			wantCode:   codeOutOfRange,
+		},
+		{
+			name:       "get cell that is not a number",
+			method:     http.MethodGet,
+			target:     "/checkbox/a/0",
+			wantStatus: http.StatusBadRequest,
+			wantCode:   codeInvalidRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, checkboxService := newTestRouter(t)
+			status, body := serve(t, router, tt.method, tt.target, tt.contentType, tt.body)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			if code, _ := body["code"].(string); code != tt.wantCode {
+				t.Errorf("code = %q, want %q", code, tt.wantCode)
+			}
+
+			for row := 0; row < testRows; row++ {
+				for col := 0; col < testCols; col++ {
+					want := tt.wantSet != nil && *tt.wantSet == [2]int{row, col}
+					if got := cellValue(t, checkboxService, row, col); got != want {
+						t.Errorf("cell (%d,%d) = %v, want %v", row, col, got, want)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import "github.com/gin-gonic/gin"
+
+// Machine-readable codes of HTTP error responses that have no WebSocket
+// counterpart; the rest share the WebSocket codes
+const (
+	codeInvalidRequest = "invalid_request"
+	codeInvalidBatch   = "invalid_batch"
+	codeRegionTooLarge = "region_too_large"
+	codeGridNotFound   = "grid_not_found"
+	codeInvalidGrid    = "invalid_grid"
+	codeGridExists     = "grid_exists"
+	codeTooManyGrids   = "too_many_grids"
+	codeDefaultGrid    = "default_grid"
+)
+
+// errorBody is the body of an error response: a human-readable error and a
+// machine-readable code. Handlers may add fields such as data to it.
+func errorBody(code, message string) gin.H {
+	return gin.H{
+		"error": message,
+		"code":  code,
+	}
+}
+
+// writeError writes an error response with the given status
+func writeError(c *gin.Context, status int, code, message string) {
+	c.JSON(status, errorBody(code, message))
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usman-007/checkbox-backend/internal/services"
+	"github.com/usman-007/checkbox-backend/internal/store"
+)
+
+// GridHandler handles HTTP requests for creating, listing and deleting grids
+type GridHandler struct {
+	gridService *services.GridService
+}
+
+// NewGridHandler creates a new instance of GridHandler
+func NewGridHandler(gridService *services.GridService) *GridHandler {
+	return &GridHandler{
+		gridService: gridService,
+	}
+}
+
+// createGridRequest is the body of POST /api/v1/grids
+type createGridRequest struct {
+	ID   string `json:"id" binding:"required"`
+	Rows int    `json:"rows" binding:"required"`
+	Cols int    `json:"cols" binding:"required"`
+}
+
+// ListGrids handles GET requests to list every grid
+func (h *GridHandler) ListGrids(c *gin.Context) {
+	grids, err := h.gridService.ListGrids(c.Request.Context())
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, codeInternal, "Failed to list grids: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, grids)
+}
+
+// CreateGrid handles POST requests to create a new grid
+func (h *GridHandler) CreateGrid(c *gin.Context) {
+	var req createGridRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, http.StatusBadRequest, codeInvalidRequest, "Invalid request body: id, rows and cols are required")
+		return
+	}
+
+	grid, err := h.gridService.CreateGrid(c.Request.Context(), req.ID, req.Rows, req.Cols)
+	switch {
+	case errors.Is(err, services.ErrInvalidGridID), errors.Is(err, services.ErrInvalidGridSize):
+		writeError(c, http.StatusBadRequest, codeInvalidGrid, err.Error())
+		return
+	case errors.Is(err, services.ErrTooManyGrids):
+		writeError(c, http.StatusConflict, codeTooManyGrids, "Grid was not created: "+err.Error())
+		return
+	case errors.Is(err, store.ErrGridExists):
+		writeError(c, http.StatusConflict, codeGridExists, "Grid already exists: "+req.ID)
+		return
+	case err != nil:
+		writeError(c, http.StatusInternalServerError, codeInternal, "Failed to create grid: "+err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, grid.GetGrid())
+}
+
+// GetGrid handles GET requests for a grid's metadata
+func (h *GridHandler) GetGrid(c *gin.Context) {
+	grid, ok := resolveGrid(c, h.gridService)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, grid.GetGrid())
+}
+
+// DeleteGrid handles DELETE requests to remove a grid
+func (h *GridHandler) DeleteGrid(c *gin.Context) {
+	id := c.Param("id")
+	err := h.gridService.DeleteGrid(c.Request.Context(), id)
+	switch {
+	case errors.Is(err, services.ErrDefaultGrid):
+		writeError(c, http.StatusBadRequest, codeDefaultGrid, err.Error())
+		return
+	case errors.Is(err, store.ErrGridNotFound):
+		writeError(c, http.StatusNotFound, codeGridNotFound, "Grid not found: "+id)
+		return
+	case err != nil:
+		writeError(c, http.StatusInternalServerError, codeInternal, "Failed to delete grid: "+err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Grid deleted successfully",
+	})
+}
+
+// resolveGrid looks up the grid named by the ":id" route parameter, or the
+// default grid on routes without one. If the grid cannot be found an error
+// response is written and ok is false.
+func resolveGrid(c *gin.Context, gridService *services.GridService) (grid *services.CheckboxService, ok bool) {
+	id := c.Param("id")
+	if id == "" {
+		id = services.DefaultGridID
+	}
+
+	grid, err := gridService.GetGrid(c.Request.Context(), id)
+	if errors.Is(err, store.ErrGridNotFound) {
+		writeError(c, http.StatusNotFound, codeGridNotFound, "Grid not found: "+id)
+		return nil, false
+	}
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, codeInternal, "Failed to load grid: "+err.Error())
+		return nil, false
+	}
+	return grid, true
+}
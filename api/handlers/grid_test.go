@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usman-007/checkbox-backend/internal/services"
+	"github.com/usman-007/checkbox-backend/internal/store"
+)
+
+func TestGridHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "create", method: http.MethodPost, target: "/grids", body: `{"id":"lobby","rows":2,"cols":2}`, wantStatus: http.StatusCreated},
+		{name: "create without size", method: http.MethodPost, target: "/grids", body: `{"id":"lobby"}`, wantStatus: http.StatusBadRequest, wantCode: codeInvalidRequest},
+		{name: "create with invalid id", method: http.MethodPost, target: "/grids", body: `{"id":"lob by","rows":2,"cols":2}`, wantStatus: http.StatusBadRequest, wantCode: codeInvalidGrid},
+		{name: "create with invalid size", method: http.MethodPost, target: "/grids", body: `{"id":"lobby","rows":-2,"cols":2}`, wantStatus: http.StatusBadRequest, wantCode: codeInvalidGrid},
+		{name: "create existing", method: http.MethodPost, target: "/grids", body: `{"id":"room","rows":2,"cols":2}`, wantStatus: http.StatusConflict, wantCode: codeGridExists},
+		{name: "delete", method: http.MethodDelete, target: "/grids/room", wantStatus: http.StatusOK},
+		{name: "delete missing", method: http.MethodDelete, target: "/grids/lobby", wantStatus: http.StatusNotFound, wantCode: codeGridNotFound},
+		{name: "delete default", method: http.MethodDelete, target: "/grids/" + services.DefaultGridID, wantStatus: http.StatusBadRequest, wantCode: codeDefaultGrid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestGridRouter(t, 3)
+			status, body := serve(t, router, tt.method, tt.target, "application/json", tt.body)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			if code, _ := body["code"].(string); code != tt.wantCode {
+				t.Errorf("code = %q, want %q", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestCreateGridOverMaxCount(t *testing.T) {
+	// The default grid and room already take both places
+	router := newTestGridRouter(t, 2)
+	status, body := serve(t, router, http.MethodPost, "/grids", "application/json", `{"id":"lobby","rows":2,"cols":2}`)
+	if status != http.StatusConflict || body["code"] != codeTooManyGrids {
+		t.Errorf("response = %d %v, want %d %q", status, body["code"], http.StatusConflict, codeTooManyGrids)
+	}
+}
+
+// newTestGridRouter serves the grid routes over a memory backend holding the
+// default grid and a grid called room, allowing at most maxGrids grids
+func newTestGridRouter(t *testing.T, maxGrids int) *gin.Engine {
+	t.Helper()
+	gridService := services.NewGridService(store.NewMemoryBackend(100, false), maxGrids)
+	for _, id := range []string{services.DefaultGridID, "room"} {
+		if _, err := gridService.InitGrid(context.Background(), id, testRows, testCols); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := NewGridHandler(gridService)
+	router := gin.New()
+	router.GET("/grids", h.ListGrids)
+	router.POST("/grids", h.CreateGrid)
+	router.DELETE("/grids/:id", h.DeleteGrid)
+	return router
+}
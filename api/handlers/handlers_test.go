@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usman-007/checkbox-backend/config"
+	"github.com/usman-007/checkbox-backend/internal/ratelimit"
+	"github.com/usman-007/checkbox-backend/internal/services"
+	"github.com/usman-007/checkbox-backend/internal/store"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// Dimensions of the test grid, and the limits test routers are configured with
+const (
+	testRows           = 4
+	testCols           = 5
+	testMaxRegionCells = 12
+	testBurst          = 5
+)
+
+// newTestRouter serves the checkbox routes of a testRows x testCols default
+// grid kept in memory, without auth. Batches may hold up to testBurst
+// operations and GETs may return up to testMaxRegionCells cells.
+func newTestRouter(t *testing.T) (*gin.Engine, *services.CheckboxService) {
+	t.Helper()
+	gridService := services.NewGridService(store.NewMemoryBackend(100, false), 10)
+	checkboxService, err := gridService.InitGrid(context.Background(), services.DefaultGridID, testRows, testCols)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.GridConfig{Rows: testRows, Cols: testCols, MaxRegionCells: testMaxRegionCells}
+	h := NewCheckboxHandler(gridService, cfg, ratelimit.NewMemoryLimiter(1000, testBurst))
+	router := gin.New()
+	router.GET("/checkbox", h.GetAllCheckboxes)
+	router.GET("/checkbox/:row/:col", h.GetCheckbox)
+	router.PATCH("/checkbox", h.UpdateCheckbox)
+	router.POST("/checkbox/toggle", h.ToggleCheckbox)
+	router.POST("/checkbox/batch", h.UpdateCheckboxes)
+	return router, checkboxService
+}
+
+// serve sends a request with body, if any, and the given Content-Type, if
+// any, and returns the response status and decoded JSON body
+func serve(t *testing.T, router *gin.Engine, method, target, contentType, body string) (int, map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var decoded map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("%s %s: response %q is not a JSON object: %v", method, target, w.Body.String(), err)
+	}
+	return w.Code, decoded
+}
+
+// cellValue returns the stored value of a cell
+func cellValue(t *testing.T, checkboxService *services.CheckboxService, row, col int) bool {
+	t.Helper()
+	checkbox, err := checkboxService.GetCheckbox(context.Background(), row, col)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return checkbox.Value != nil && *checkbox.Value
+}
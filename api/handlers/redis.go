@@ -55,14 +55,3 @@ func (h *RedisTestHandler) TestRedis(c *gin.Context) {
 		"retrieved_value": retrievedValue,
 	})
 }
-
-// implent the clear redis function here
-func (h *RedisTestHandler) ClearRedis(c *gin.Context) {
-	ctx := c.Request.Context()
-	err := h.redisClient.FlushAll(ctx).Err()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to clear Redis database: " + err.Error(),
-		})
-	}
-}
\ No newline at end of file
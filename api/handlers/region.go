@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usman-007/checkbox-backend/internal/models"
+	"github.com/usman-007/checkbox-backend/internal/services"
+)
+
+// parseRegion reads a region from the row_start, row_end, col_start and
+// col_end query parameters. Ends are exclusive, and a missing parameter
+// defaults to the grid's edge, so no parameters select the whole grid. On
+// failure it writes a 400 response and returns false.
+func parseRegion(c *gin.Context, checkboxService *services.CheckboxService) (region models.Region, ok bool) {
+	region = checkboxService.GetGrid().Region()
+	params := []struct {
+		name  string
+		value *int
+	}{
+		{"row_start", &region.RowStart},
+		{"row_end", &region.RowEnd},
+		{"col_start", &region.ColStart},
+		{"col_end", &region.ColEnd},
+	}
+	for _, param := range params {
+		raw := c.Query(param.name)
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			writeError(c, http.StatusBadRequest, codeInvalidRequest, "Invalid "+param.name+" parameter: must be an integer")
+			return region, false
+		}
+		*param.value = v
+	}
+
+	if err := checkboxService.ValidateRegion(region); err != nil {
+		writeError(c, http.StatusBadRequest, codeInvalidRegion, err.Error())
+		return region, false
+	}
+	return region, true
+}
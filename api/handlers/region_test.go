@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usman-007/checkbox-backend/internal/models"
+)
+
+func TestParseRegion(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		want       models.Region
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "defaults to the whole grid", want: models.Region{RowEnd: testRows, ColEnd: testCols}},
+		{name: "start only", query: "?row_start=1&col_start=2", want: models.Region{RowStart: 1, RowEnd: testRows, ColStart: 2, ColEnd: testCols}},
+		{name: "end only", query: "?row_end=2&col_end=3", want: models.Region{RowEnd: 2, ColEnd: 3}},
+		{name: "single cell", query: "?row_start=3&row_end=4&col_start=4&col_end=5", want: models.Region{RowStart: 3, RowEnd: 4, ColStart: 4, ColEnd: 5}},
+		{name: "not an integer", query: "?row_end=two", wantStatus: http.StatusBadRequest, wantCode: codeInvalidRequest},
+		{name: "negative start", query: "?col_start=-1", wantStatus: http.StatusBadRequest, wantCode: codeInvalidRegion},
+		{name: "end past the grid", query: "?row_end=5", wantStatus: http.StatusBadRequest, wantCode: codeInvalidRegion},
+		{name: "empty", query: "?col_start=2&col_end=2", wantStatus: http.StatusBadRequest, wantCode: codeInvalidRegion},
+		{name: "reversed", query: "?row_start=3&row_end=1", wantStatus: http.StatusBadRequest, wantCode: codeInvalidRegion},
+	}
+
+	_, checkboxService := newTestRouter(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/checkbox"+tt.query, nil)
+
+			region, ok := parseRegion(c, checkboxService)
+			if ok != (tt.wantCode == "") {
+				t.Fatalf("ok = %v, response %s", ok, w.Body.String())
+			}
+			if ok {
+				if region != tt.want {
+					t.Errorf("region = %+v, want %+v", region, tt.want)
+				}
+				return
+			}
+
+			var body map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.wantStatus || body["code"] != tt.wantCode {
+				t.Errorf("response = %d %v, want %d %q", w.Code, body["code"], tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestGetAllCheckboxes(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantCode   string
+		// wantCells is the number of cells returned
+		wantCells int
+	}{
+		{name: "region", query: "?row_start=1&row_end=3", wantStatus: http.StatusOK, wantCells: 10},
+		{name: "region at the size limit", query: "?row_end=3&col_end=4", wantStatus: http.StatusOK, wantCells: testMaxRegionCells},
+		{name: "whole grid too large", wantStatus: http.StatusBadRequest, wantCode: codeRegionTooLarge},
+		{name: "region too large", query: "?row_end=3", wantStatus: http.StatusBadRequest, wantCode: codeRegionTooLarge},
+		{name: "invalid region", query: "?row_start=2&row_end=1", wantStatus: http.StatusBadRequest, wantCode: codeInvalidRegion},
+		{name: "unknown format", query: "?row_end=1&format=xml", wantStatus: http.StatusBadRequest, wantCode: codeInvalidRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, checkboxService := newTestRouter(t)
+			if _, err := checkboxService.UpdateCheckboxState(context.Background(), 1, 2, true); err != nil {
+				t.Fatal(err)
+			}
+
+			status, body := serve(t, router, http.MethodGet, "/checkbox"+tt.query, "", "")
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			if tt.wantCode != "" {
+				if body["code"] != tt.wantCode {
+					t.Errorf("code = %v, want %q", body["code"], tt.wantCode)
+				}
+				return
+			}
+			if len(body) != tt.wantCells {
+				t.Errorf("got %d cells, want %d", len(body), tt.wantCells)
+			}
+			if body["states:(1,2)"] != true {
+				t.Errorf("states:(1,2) = %v, want true", body["states:(1,2)"])
+			}
+		})
+	}
+}
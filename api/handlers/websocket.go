@@ -2,195 +2,692 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"github.com/redis/go-redis/v9"
+	"github.com/usman-007/checkbox-backend/config"
+	"github.com/usman-007/checkbox-backend/internal/auth"
+	"github.com/usman-007/checkbox-backend/internal/models"
 	"github.com/usman-007/checkbox-backend/internal/monitoring"
+	"github.com/usman-007/checkbox-backend/internal/origin"
+	"github.com/usman-007/checkbox-backend/internal/ratelimit"
 	"github.com/usman-007/checkbox-backend/internal/services"
+	"github.com/usman-007/checkbox-backend/internal/session"
+	"github.com/usman-007/checkbox-backend/internal/store"
+	"github.com/usman-007/checkbox-backend/pkg/binproto"
 )
 
 type WebSocketHandler struct {
-	checkboxService *services.CheckboxService
-	redisClient     *redis.Client
-	clients  map[*websocket.Conn]bool
-	mutex    sync.Mutex 
-	upgrader websocket.Upgrader
+	gridService *services.GridService
+	cfg         config.WebSocketConfig
+	// limiter rate limits the checkbox updates clients send
+	limiter ratelimit.Limiter
+	// maxRegionCells caps the regions of legacy clients, whose snapshots
+	// spell out every cell. Other clients may watch up to
+	// config.MaxGridCells cells.
+	maxRegionCells int
+	// clients holds the connections watching each grid, keyed by grid ID
+	clients map[string]map[*websocket.Conn]*wsClient
+	// subscriptions holds the update listener of each grid that has clients
+	subscriptions map[string]*gridSubscription
+	mutex         sync.Mutex
+	upgrader      websocket.Upgrader
+}
+
+// wsFrame is a message queued for a connection's writer
+type wsFrame struct {
+	messageType int
+	data        []byte
+}
+
+// wsClient is a registered connection. Everything except conn is guarded by
+// the handler's mutex.
+type wsClient struct {
+	conn   *websocket.Conn
+	gridID string
+	// ctx carries the connection's session, identity and rate limit key to
+	// the updates it makes
+	ctx context.Context
+	// grid is the grid's shape, which some codecs need to encode changes
+	grid models.Grid
+	// region is the part of the grid the client is watching. Changes outside
+	// it are not sent.
+	region models.Region
+	// meta is set for clients that want the cell metadata of each change
+	meta bool
+	// codec encodes messages in the subprotocol negotiated by the client
+	codec wsCodec
+	// send queues frames for the connection's writer goroutine. It is closed
+	// when the client is removed, which makes the writer close the connection.
+	send chan wsFrame
+	// closeCode and closeReason, when set, are sent in a close frame once the
+	// writer has drained send
+	closeCode   int
+	closeReason string
+	// ready is false until the initial state has been queued. Until then
+	// broadcast changes are held in pending so they cannot overtake it.
+	ready   bool
+	pending []store.Change
+	// version is the newest grid version queued for the client. Broadcast
+	// changes at or below it are duplicates and are skipped.
+	version int64
+	// syncs counts the syncClient calls started for the client, so an
+	// earlier one finishing late can tell it has been superseded
+	syncs uint64
+}
+
+// gridSubscription is a running listener for one grid's updates
+type gridSubscription struct {
+	cancel context.CancelFunc
 }
 
 // NewWebSocketHandler creates a new instance of WebSocketHandler
-func NewWebSocketHandler(checkboxService *services.CheckboxService, redisClient *redis.Client) *WebSocketHandler {
-	if checkboxService == nil {
-		log.Fatal("CheckboxService is nil in NewWebSocketHandler")
-	}
-	if redisClient == nil {
-		log.Fatal("RedisClient is nil in NewWebSocketHandler")
+// limiter rate limits the checkbox updates clients send over the socket, and
+// only browser pages from origins in allowlist may connect. Legacy clients
+// may watch at most maxRegionCells cells.
+func NewWebSocketHandler(gridService *services.GridService, cfg config.WebSocketConfig, limiter ratelimit.Limiter, allowlist *origin.Allowlist, maxRegionCells int) *WebSocketHandler {
+	if gridService == nil {
+		log.Fatal("GridService is nil in NewWebSocketHandler")
 	}
 
 	return &WebSocketHandler{
-		checkboxService: checkboxService,
-		redisClient:     redisClient,
-		clients: make(map[*websocket.Conn]bool),
+		gridService:    gridService,
+		cfg:            cfg,
+		limiter:        limiter,
+		maxRegionCells: maxRegionCells,
+		clients:        make(map[string]map[*websocket.Conn]*wsClient),
+		subscriptions:  make(map[string]*gridSubscription),
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
-			// Allow all origins for development - Consider restricting in production
+			Subprotocols:    []string{JSONSubprotocol, binproto.Subprotocol},
+			// Only pages from allowed origins may open connections, so other
+			// sites cannot ride on a visitor's session cookie
 			CheckOrigin: func(r *http.Request) bool {
-				// log.Printf("WebSocket CheckOrigin: Host=%s, Origin=%s", r.Host, r.Header.Get("Origin")) // Debug logging
-				return true // Be careful with this in production
+				return allowlist.Allowed(r.Header.Get("Origin"), r.Host)
 			},
 		},
 	}
 }
 
-// HandleWebSocket upgrades HTTP connection to WebSocket and handles the connection lifecycle
+// HandleWebSocket upgrades HTTP connection to WebSocket and handles the connection lifecycle.
+// Clients reconnecting with ?since=<version> are sent only the changes they
+// missed, falling back to a full snapshot when those are no longer logged.
+// The row_start, row_end, col_start and col_end parameters limit the client
+// to a region of the grid, which it can later move with a subscribe message.
+// With meta=true, JSON protocol deltas carry each change's cell metadata.
 func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
-	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	// Resolve the grid and parameters before upgrading so errors get a plain HTTP response
+	checkboxService, ok := resolveGrid(c, h.gridService)
+	if !ok {
+		return
+	}
+	gridID := checkboxService.ID
+
+	since := int64(-1)
+	if sinceStr := c.Query("since"); sinceStr != "" {
+		v, err := strconv.ParseInt(sinceStr, 10, 64)
+		if err != nil || v < 0 {
+			writeError(c, http.StatusBadRequest, codeInvalidRequest, "Invalid since parameter: must be a non-negative integer")
+			return
+		}
+		since = v
+	}
+	region, ok := parseRegion(c, checkboxService)
+	if !ok {
+		return
+	}
+	if message, tooLarge := h.regionTooLarge(h.negotiatedCodec(c.Request), region); tooLarge {
+		writeError(c, http.StatusBadRequest, codeRegionTooLarge, message)
+		return
+	}
+	meta := c.Query("meta")
+	if meta != "" && meta != "true" && meta != "false" {
+		writeError(c, http.StatusBadRequest, codeInvalidRequest, "Invalid meta parameter: must be 'true' or 'false'")
+		return
+	}
+
+	// Pass on headers set by middleware, such as a newly issued session cookie
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, c.Writer.Header())
 	if err != nil {
 		log.Printf("Failed to upgrade connection to WebSocket: %v", err)
 		return
 	}
 	defer conn.Close()
-	log.Printf("WebSocket connection established: %s", conn.RemoteAddr())
+	log.Printf("WebSocket connection established: %s (grid %q, subprotocol %q)", conn.RemoteAddr(), gridID, conn.Subprotocol())
 
-	// Register new client connection
-	h.mutex.Lock()
-	if h.clients == nil {
-		// This should not happen if NewWebSocketHandler is used correctly, but defensive check
-		log.Println("CRITICAL: h.clients map is nil during client registration!")
-		h.clients = make(map[*websocket.Conn]bool)
+	// Register new client connection, starting the grid's listener if this is its first client
+	client := &wsClient{
+		conn:   conn,
+		gridID: gridID,
+		ctx:    sessionContext(c.Request.Context()),
+		grid:   checkboxService.GetGrid(),
+		region: region,
+		meta:   meta == "true",
+		codec:  codecFor(conn.Subprotocol()),
+		send:   make(chan wsFrame, h.cfg.SendQueueSize),
+	}
+	if err := h.register(checkboxService, client); err != nil {
+		log.Printf("Failed to subscribe to updates for grid %q: %v", gridID, err)
+		return
 	}
-	h.clients[conn] = true
-	// Update WebSocket metrics
-	monitoring.WebSocketConnections.Inc()
-	h.mutex.Unlock()
+
+	go h.writePump(client)
 
 	// --- Unregister client when the connection closes ---
 	defer func() {
 		h.mutex.Lock()
-		if _, ok := h.clients[conn]; ok {
-			delete(h.clients, conn)
-			// Update WebSocket metrics
-			monitoring.WebSocketConnections.Dec()
-			log.Printf("Client unregistered: %s. Remaining clients: %d", conn.RemoteAddr(), len(h.clients))
+		if _, ok := h.clients[gridID][conn]; ok {
+			h.removeClientLocked(gridID, client)
+			log.Printf("Client unregistered: %s. Remaining clients on grid %q: %d", conn.RemoteAddr(), gridID, len(h.clients[gridID]))
 		} else {
 			log.Printf("Attempted to unregister client %s but it was already removed.", conn.RemoteAddr())
 		}
+		// Stop listening for a grid nobody is watching
+		if len(h.clients[gridID]) == 0 {
+			h.unsubscribeLocked(gridID)
+		}
 		h.mutex.Unlock()
 	}()
 	// --- End Unregister ---
 
-	// --- Send initial state to the newly connected client ---
-		checkboxes, err := h.checkboxService.GetAllCheckboxes()
-		if err != nil {
-			log.Printf("Failed to get initial checkbox state for client %s: %v", conn.RemoteAddr(), err)
-		} else {
-			// Use WriteJSON for the initial state as it's likely a complex object/slice
-			h.mutex.Lock() // Lock needed if GetAllCheckboxes modifies shared state (unlikely) or if WriteJSON isn't thread-safe for the conn
-			err := conn.WriteJSON(checkboxes)
-			h.mutex.Unlock()
-			if err != nil {
-				log.Printf("Failed to send initial state to client %s: %v", conn.RemoteAddr(), err)
-				return 
-			}
-		}
-
-	// --- End Initial State ---
+	if err := h.sendInitialState(client, checkboxService, since); err != nil {
+		log.Printf("Failed to send initial state to client %s: %v", conn.RemoteAddr(), err)
+		return
+	}
 
-	// --- Keep-alive and Disconnect Detection Loop ---
-	// Read messages from the client. This loop primarily serves to detect
-	// when the client disconnects. We don't expect specific messages here
-	// unless the client is designed to send them.
+	// --- Read Loop ---
+	// Read messages from the client: checkbox updates and region subscriptions, answered with an ack
+	// or error. The loop also detects when the client disconnects. The
+	// writer pings the client regularly; a peer that stops answering hits the
+	// read deadline and is unregistered. Client messages are small, so larger
+	// ones close the connection rather than being buffered.
+	conn.SetReadLimit(int64(h.cfg.MaxMessageSize))
+	conn.SetReadDeadline(time.Now().Add(h.cfg.PongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(h.cfg.PongTimeout))
+	})
 	for {
 		// ReadMessage blocks until a message is received or an error occurs (like disconnect)
 		messageType, message, err := conn.ReadMessage()
 		if err != nil {
-			// Check if the error indicates a normal closure or an unexpected error
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseNormalClosure) {
+			// Check if the error indicates a dead peer, a normal closure or an unexpected error
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				log.Printf("Client %s stopped responding to pings.", conn.RemoteAddr())
+			} else if errors.Is(err, websocket.ErrReadLimit) {
+				log.Printf("Client %s sent a message larger than %d bytes.", conn.RemoteAddr(), h.cfg.MaxMessageSize)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseNormalClosure) {
 				log.Printf("Error reading message from client %s: %v", conn.RemoteAddr(), err)
 			} else {
 				log.Printf("Client %s disconnected.", conn.RemoteAddr())
 			}
 			break
 		}
+		// Any message proves the peer is alive
+		conn.SetReadDeadline(time.Now().Add(h.cfg.PongTimeout))
 		// Record incoming message metric
 		monitoring.WebSocketMessagesTotal.WithLabelValues("received").Inc()
-		// Optional: Handle messages received from the client if needed
-		log.Printf("Received message from client %s (type %d): %s", conn.RemoteAddr(), messageType, message)
+		h.handleClientMessage(client, checkboxService, messageType, message)
+	}
+
+}
+
+// writePump is the only goroutine writing to a client's connection. It sends
+// queued frames and periodic pings until the queue is closed or a write
+// fails, then closes the connection, which also ends the read loop in
+// HandleWebSocket.
+func (h *WebSocketHandler) writePump(client *wsClient) {
+	conn := client.conn
+	ticker := time.NewTicker(h.cfg.PingInterval)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	for {
+		select {
+		case frame, ok := <-client.send:
+			if !ok {
+				// The queue was closed: say why if there is a reason worth telling the client
+				h.mutex.Lock()
+				code, reason := client.closeCode, client.closeReason
+				h.mutex.Unlock()
+				if code != 0 {
+					conn.SetWriteDeadline(time.Now().Add(h.cfg.WriteTimeout))
+					conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+				}
+				return
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(h.cfg.WriteTimeout))
+			if err := conn.WriteMessage(frame.messageType, frame.data); err != nil {
+				log.Printf("Error sending message to client %s: %v. Closing.", conn.RemoteAddr(), err)
+				return
+			}
+			// Record outgoing message metric
+			monitoring.WebSocketMessagesTotal.WithLabelValues("sent").Inc()
+
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(h.cfg.WriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Printf("Error pinging client %s: %v. Closing.", conn.RemoteAddr(), err)
+				return
+			}
+		}
+	}
+}
+
+// sendInitialState brings a new client up to date: with the changes after
+// since when the change log still covers them, otherwise with a snapshot of
+// its region. since is -1 for clients that are not resuming.
+func (h *WebSocketHandler) sendInitialState(client *wsClient, checkboxService *services.CheckboxService, since int64) error {
+	ctx := context.Background()
+
+	return h.syncClient(client, nil, func(region models.Region) ([]wsFrame, int64, error) {
+		if since >= 0 {
+			changes, ok, err := checkboxService.ChangesSince(ctx, since)
+			if err != nil {
+				log.Printf("Failed to read change log for client %s, sending snapshot instead: %v", client.conn.RemoteAddr(), err)
+			} else if ok {
+				version := since
+				if len(changes) > 0 {
+					version = changes[len(changes)-1].Version
+				}
+
+				notice, err := client.codec.notice(version, codeResumed, fmt.Sprintf("resumed from version %d with %d changes", since, len(changes)))
+				if err != nil {
+					return nil, 0, err
+				}
+				var deltas []wsFrame
+				if visible := client.view(region, changes); len(visible) > 0 {
+					deltas, err = client.codec.changes(client.grid, visible)
+					if err != nil {
+						return nil, 0, err
+					}
+				}
+				log.Printf("Resuming client %s from version %d with %d changes", client.conn.RemoteAddr(), since, len(changes))
+				return append(notice, deltas...), version, nil
+			}
+		}
+
+		return h.readRegion(client, checkboxService, region)
+	})
+}
+
+// sendRegion moves a client to region and queues a snapshot of it, returning
+// the snapshot's version
+func (h *WebSocketHandler) sendRegion(client *wsClient, checkboxService *services.CheckboxService, region models.Region) (int64, error) {
+	var version int64
+	err := h.syncClient(client, &region, func(region models.Region) ([]wsFrame, int64, error) {
+		frames, v, err := h.readRegion(client, checkboxService, region)
+		version = v
+		return frames, v, err
+	})
+	return version, err
+}
+
+// readRegion reads and encodes a snapshot of region for a client
+func (h *WebSocketHandler) readRegion(client *wsClient, checkboxService *services.CheckboxService, region models.Region) ([]wsFrame, int64, error) {
+	snapshot, err := checkboxService.RegionSnapshot(context.Background(), region)
+	if err != nil {
+		return nil, 0, err
+	}
+	frames, err := client.codec.snapshot(client.grid, region, snapshot)
+	if err != nil {
+		return nil, 0, err
+	}
+	return frames, snapshot.Version, nil
+}
+
+// syncClient points a client at region, or leaves it at its current region
+// when region is nil, and queues the frames read returns for that region,
+// which bring the client up to the version read also returns. read runs
+// without h.mutex held; broadcasts arriving meanwhile are held back and
+// queued after its frames. If read fails the client keeps its old region.
+// When syncs overlap only the last one started queues its frames, since it
+// read the newest state for the newest region.
+func (h *WebSocketHandler) syncClient(client *wsClient, region *models.Region, read func(region models.Region) ([]wsFrame, int64, error)) error {
+	h.mutex.Lock()
+	previous := client.region
+	if region != nil {
+		client.region = *region
+	}
+	target := client.region
+	client.ready = false
+	client.syncs++
+	sync := client.syncs
+	h.mutex.Unlock()
+
+	frames, version, err := read(target)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.clients[client.gridID][client.conn]; !ok {
+		// Removed while the state was being read
+		return err
 	}
+	if sync != client.syncs {
+		// Superseded by a later sync, which will bring the client up to date
+		return err
+	}
+	if err != nil {
+		client.region = previous
+	} else {
+		for _, frame := range frames {
+			if !h.enqueueLocked(client.gridID, client, frame) {
+				return nil
+			}
+		}
+		client.version = version
+	}
+	client.ready = true
 
+	// Flush the broadcasts that arrived while the state was being read
+	if pending := client.pending; len(pending) > 0 {
+		client.pending = nil
+		h.deliverLocked(client.gridID, client, pending, make(map[encodingKey][]wsFrame))
+	}
+	return err
 }
 
-// StartRedisSubscription starts listening for Redis Pub/Sub messages and broadcasts updates
-func (h *WebSocketHandler) StartRedisSubscription() {
+// register adds a client to its grid, starting the grid's update listener
+// if it has none. Subscribing may wait on Redis, so it happens without
+// h.mutex held, and a listener another client started meanwhile wins.
+func (h *WebSocketHandler) register(checkboxService *services.CheckboxService, client *wsClient) error {
+	gridID := checkboxService.ID
+	h.mutex.Lock()
+	_, subscribed := h.subscriptions[gridID]
+	if subscribed {
+		h.addClientLocked(client)
+	}
+	h.mutex.Unlock()
+	if subscribed {
+		return nil
+	}
 
-	ctx := context.Background() 
-	pubsub := h.redisClient.Subscribe(ctx, "checkbox_updates")
-	_, err := pubsub.Receive(ctx)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := checkboxService.Subscribe(ctx)
 	if err != nil {
-		log.Printf("FATAL: Failed to subscribe to Redis channel 'checkbox_updates': %v", err)
-		// Depending on application structure, might want to panic or signal failure
-		return // Exit if subscription fails
+		cancel()
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.subscriptions[gridID]; ok {
+		cancel()
+	} else {
+		sub := &gridSubscription{cancel: cancel}
+		h.subscriptions[gridID] = sub
+		h.clients[gridID] = make(map[*websocket.Conn]*wsClient)
+		go h.broadcast(gridID, sub, ch)
+	}
+	h.addClientLocked(client)
+	return nil
+}
+
+// addClientLocked adds a client to its grid, which must have a listener.
+// h.mutex must be held.
+func (h *WebSocketHandler) addClientLocked(client *wsClient) {
+	h.clients[client.gridID][client.conn] = client
+	// Update WebSocket metrics
+	monitoring.WebSocketConnections.Inc()
+}
+
+// unsubscribeLocked stops a grid's update listener. h.mutex must be held.
+func (h *WebSocketHandler) unsubscribeLocked(gridID string) {
+	if sub, ok := h.subscriptions[gridID]; ok {
+		sub.cancel()
+		delete(h.subscriptions, gridID)
 	}
+	delete(h.clients, gridID)
+}
+
+// removeClientLocked unregisters a client and closes its send queue so its
+// writer shuts the connection down. h.mutex must be held.
+func (h *WebSocketHandler) removeClientLocked(gridID string, client *wsClient) {
+	delete(h.clients[gridID], client.conn)
+	close(client.send)
+	// Update WebSocket metrics
+	monitoring.WebSocketConnections.Dec()
+}
+
+// enqueueLocked queues a frame for a client without blocking. It reports
+// whether the client is still registered. h.mutex must be held.
+func (h *WebSocketHandler) enqueueLocked(gridID string, client *wsClient, frame wsFrame) bool {
+	select {
+	case client.send <- frame:
+		return true
+	default:
+		return h.overflowLocked(gridID, client)
+	}
+}
+
+// overflowLocked applies the slow client policy to a client that cannot take
+// another message: the message is dropped or the client is disconnected. It
+// reports whether the client is still registered. h.mutex must be held.
+func (h *WebSocketHandler) overflowLocked(gridID string, client *wsClient) bool {
+	if h.cfg.SlowClientPolicy == config.SlowClientDrop {
+		monitoring.WebSocketDroppedMessages.Inc()
+		return true
+	}
+
+	log.Printf("Evicting slow client %s: send queue of %d frames is full", client.conn.RemoteAddr(), cap(client.send))
+	monitoring.WebSocketEvictions.Inc()
+	client.closeCode = websocket.ClosePolicyViolation
+	client.closeReason = "client too slow"
+	h.removeClientLocked(gridID, client)
+	return false
+}
 
-	// Get the channel for receiving messages. This is the idiomatic way.
-	ch := pubsub.Channel()
+// deliverLocked queues a batch of changes, ordered by version, for a client,
+// leaving out any it already has or that lie outside its region. encoded caches the whole batch's frames per
+// encoding across the clients of one broadcast. It reports whether the client is
+// still registered. h.mutex must be held.
+func (h *WebSocketHandler) deliverLocked(gridID string, client *wsClient, changes []store.Change, encoded map[encodingKey][]wsFrame) bool {
+	if !client.ready {
+		// Hold the changes back until the initial state is queued, treating
+		// the client as slow if as many pile up as a full queue would hold
+		if len(client.pending)+len(changes) > cap(client.send) {
+			return h.overflowLocked(gridID, client)
+		}
+		client.pending = append(client.pending, changes...)
+		return true
+	}
 
-	// Start a goroutine to process incoming messages from the channel
-	go func() {
-		// Ensure resources are cleaned up when this goroutine exits.
-		// Closing the pubsub here will also cause the range loop over 'ch' below to terminate.
-		defer pubsub.Close()
-		defer log.Println("Exiting Redis message listener goroutine.")
+	// Skip changes the initial state already included
+	skip := 0
+	for skip < len(changes) && changes[skip].Version <= client.version {
+		skip++
+	}
+	if skip == len(changes) {
+		return true
+	}
 
-		// This loop reads from the channel until it's closed.
-		for msg := range ch {
-			// Log the raw payload received
-			log.Printf("Received message payload from Redis 'checkbox_updates': %s", msg.Payload)
+	version := changes[len(changes)-1].Version
+	visible := client.view(client.region, changes[skip:])
+	if len(visible) == 0 {
+		client.version = version
+		return true
+	}
 
-			h.mutex.Lock() 
+	// The cached frames are for the whole batch
+	whole := len(visible) == len(changes)
+	key := encodingKey{client.codec, client.meta}
+	frames, ok := encoded[key]
+	if !ok || !whole {
+		var err error
+		frames, err = client.codec.changes(client.grid, visible)
+		if err != nil {
+			log.Printf("Failed to encode updates for grid %q: %v", gridID, err)
+			return true
+		}
+		if whole {
+			encoded[key] = frames
+		}
+	}
+	for _, frame := range frames {
+		if !h.enqueueLocked(gridID, client, frame) {
+			return false
+		}
+	}
+	client.version = version
+	return true
+}
 
-			if h.clients == nil {
-				log.Println("CRITICAL: h.clients map is nil in broadcast goroutine!")
-				h.mutex.Unlock() // Unlock before skipping
-				continue       // Skip this message if map is nil
+// negotiatedCodec returns the codec the upgrade of r will settle on, picking
+// the subprotocol the same way as the upgrader: the client's first choice
+// that the server speaks
+func (h *WebSocketHandler) negotiatedCodec(r *http.Request) wsCodec {
+	for _, requested := range websocket.Subprotocols(r) {
+		for _, supported := range h.upgrader.Subprotocols {
+			if requested == supported {
+				return codecFor(supported)
 			}
+		}
+	}
+	return legacyProtocol
+}
 
-			clientCount := len(h.clients)
-			broadcastCount := 0
+// regionTooLarge reports whether region is too large for a client speaking
+// codec, with a message saying why. The legacy format, which spells out
+// every cell of a snapshot, is limited to the same cap as a GET. The others
+// send bitmaps, limited to config.MaxGridCells cells, which only grids
+// stored before that cap existed can exceed.
+func (h *WebSocketHandler) regionTooLarge(codec wsCodec, region models.Region) (string, bool) {
+	if region.Cols() == 0 {
+		return "", false
+	}
+	// Compare by division so a huge region cannot overflow past the check
+	if codec == legacyProtocol && region.Rows() > h.maxRegionCells/region.Cols() {
+		return fmt.Sprintf("Region of %dx%d cells is too large for the legacy protocol: watch at most %d cells, or use the %s or %s subprotocol",
+			region.Rows(), region.Cols(), h.maxRegionCells, JSONSubprotocol, binproto.Subprotocol), true
+	}
+	if region.Rows() > config.MaxGridCells/region.Cols() {
+		return fmt.Sprintf("Region of %dx%d cells is too large: watch at most %d cells",
+			region.Rows(), region.Cols(), config.MaxGridCells), true
+	}
+	return "", false
+}
 
-			// Iterate over the clients map safely
-			for client, active := range h.clients {
-				// If you use the boolean value, you can check if a client is marked inactive
-				if !active {
-					continue
-				}
+// sessionContext returns a context carrying only the session, identity and
+// rate limit key of ctx, for work that outlives the request ctx belongs to
+func sessionContext(ctx context.Context) context.Context {
+	detached := context.Background()
+	if s, ok := session.FromContext(ctx); ok {
+		detached = session.NewContext(detached, s)
+	}
+	if identity, ok := auth.FromContext(ctx); ok {
+		detached = auth.NewContext(detached, identity)
+	}
+	if key := ratelimit.KeyFrom(ctx); key != "" {
+		detached = ratelimit.NewContext(detached, key)
+	}
+	return detached
+}
 
-				err := client.WriteMessage(websocket.TextMessage, []byte(msg.Payload))
+// encodingKey identifies the clients a batch of changes encodes the same for
+type encodingKey struct {
+	codec wsCodec
+	meta  bool
+}
 
-				if err != nil {
-					// Log the error and remove the problematic client connection
-					log.Printf("Error sending message to client %p (%s): %v. Closing and removing.", client, client.RemoteAddr(), err)
-					// Close the connection (best effort, might already be closed)
-					client.Close()
-					// Remove the client from the map
-					delete(h.clients, client)
-				} else {
-					// Record outgoing message metric
-					monitoring.WebSocketMessagesTotal.WithLabelValues("sent").Inc()
-					// log.Printf("Successfully sent message to client %p (%s)", client, client.RemoteAddr()) // Verbose logging
-					broadcastCount++
-				}
-			} // End of client loop
+// view returns the changes the client should be sent: those inside region,
+// which callers holding h.mutex take from client.region, stripped of cell
+// metadata unless it asked for it
+func (client *wsClient) view(region models.Region, changes []store.Change) []store.Change {
+	visible := inRegion(changes, region)
+	if client.meta {
+		return visible
+	}
+	for i, change := range visible {
+		if change.Meta == nil {
+			continue
+		}
+		stripped := append([]store.Change(nil), visible...)
+		for j := i; j < len(stripped); j++ {
+			stripped[j].Meta = nil
+		}
+		return stripped
+	}
+	return visible
+}
+
+// inRegion returns the changes that fall within region, reusing changes
+// when all of them do
+func inRegion(changes []store.Change, region models.Region) []store.Change {
+	for i, change := range changes {
+		if region.Contains(change.Row, change.Col) {
+			continue
+		}
+		visible := append([]store.Change(nil), changes[:i]...)
+		for _, change := range changes[i+1:] {
+			if region.Contains(change.Row, change.Col) {
+				visible = append(visible, change)
+			}
+		}
+		return visible
+	}
+	return changes
+}
+
+// broadcast sends the changes received on ch to the clients of the grid,
+// collecting them for BatchInterval at a time so a burst becomes one delta.
+// It never blocks on socket I/O.
+func (h *WebSocketHandler) broadcast(gridID string, sub *gridSubscription, ch <-chan store.Change) {
+	defer log.Printf("Exiting update listener for grid %q.", gridID)
 
-			if clientCount > 0 {
-				log.Printf("Finished broadcasting message to %d out of %d initial clients.", broadcastCount, clientCount)
+	batch := newChangeBatch()
+	var flush <-chan time.Time
+	// Collect changes until the batch's interval is up or the channel
+	// closes, then flush them
+	for open := true; open; {
+		select {
+		case change, ok := <-ch:
+			if !ok {
+				open = false
+				break
 			}
+			if batch.empty() {
+				flush = time.After(h.cfg.BatchInterval)
+			}
+			batch.add(change)
+			continue
+		case <-flush:
+		}
+		h.flush(gridID, batch)
+		flush = nil
+	}
 
-			h.mutex.Unlock() 
+	// The channel also closes when the grid is deleted. If this listener is
+	// still the grid's current one, disconnect everyone still watching it.
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.subscriptions[gridID] != sub {
+		return
+	}
+	for _, client := range h.clients[gridID] {
+		if frames, err := client.codec.notice(client.version, codeGridDeleted, "grid deleted"); err == nil {
+			for _, frame := range frames {
+				select {
+				case client.send <- frame:
+				default:
+				}
+			}
 		}
-	}() 
-}
\ No newline at end of file
+		client.closeCode = websocket.CloseGoingAway
+		client.closeReason = "grid deleted"
+		h.removeClientLocked(gridID, client)
+	}
+	h.unsubscribeLocked(gridID)
+}
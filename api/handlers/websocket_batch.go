@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/usman-007/checkbox-backend/internal/models"
+	"github.com/usman-007/checkbox-backend/internal/monitoring"
+	"github.com/usman-007/checkbox-backend/internal/store"
+)
+
+// cellKey identifies a cell within a grid
+type cellKey struct {
+	row, col int
+}
+
+// changeBatch collects a grid's changes between broadcasts. A cell changed
+// more than once keeps only its latest change, which is all any client
+// needs whatever version it has seen. A reset of the grid discards the
+// changes before it, since every client is sent a new snapshot instead.
+type changeBatch struct {
+	changes []store.Change
+	index   map[cellKey]int
+	// reset is set when the grid was reset since the last broadcast
+	reset bool
+	// started is when the batch's first change arrived
+	started time.Time
+}
+
+func newChangeBatch() *changeBatch {
+	return &changeBatch{index: make(map[cellKey]int)}
+}
+
+func (b *changeBatch) empty() bool {
+	return len(b.changes) == 0 && !b.reset
+}
+
+// add records a change, replacing any earlier change to the same cell
+func (b *changeBatch) add(change store.Change) {
+	if b.empty() {
+		b.started = time.Now()
+	}
+	if change.Reset {
+		b.reset = true
+		kept := b.changes[:0]
+		for _, c := range b.changes {
+			if c.Version > change.Version {
+				kept = append(kept, c)
+			}
+		}
+		b.changes = kept
+		clear(b.index)
+		for i, c := range b.changes {
+			b.index[cellKey{c.Row, c.Col}] = i
+		}
+		return
+	}
+	key := cellKey{change.Row, change.Col}
+	if i, ok := b.index[key]; ok {
+		if change.Version > b.changes[i].Version {
+			b.changes[i] = change
+		}
+		return
+	}
+	b.index[key] = len(b.changes)
+	b.changes = append(b.changes, change)
+}
+
+// take returns the batch's changes ordered by version and whether the grid
+// was reset before them, and empties the batch
+func (b *changeBatch) take() (changes []store.Change, reset bool) {
+	changes, reset = b.changes, b.reset
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Version < changes[j].Version })
+	b.changes = nil
+	b.reset = false
+	clear(b.index)
+	return changes, reset
+}
+
+// flush queues a batch's changes for every client of the grid as one delta,
+// after a new snapshot if the grid was reset
+func (h *WebSocketHandler) flush(gridID string, batch *changeBatch) {
+	if batch.empty() {
+		return
+	}
+	started := batch.started
+	changes, reset := batch.take()
+	if reset {
+		h.resync(gridID)
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	encoded := make(map[encodingKey][]wsFrame)
+	h.mutex.Lock()
+	for _, client := range h.clients[gridID] {
+		h.deliverLocked(gridID, client, changes, encoded)
+	}
+	h.mutex.Unlock()
+
+	monitoring.WebSocketBatchSize.Observe(float64(len(changes)))
+	monitoring.WebSocketFlushLatency.Observe(time.Since(started).Seconds())
+}
+
+// resync sends every client of a grid that was reset a notice and a new
+// snapshot of its region
+func (h *WebSocketHandler) resync(gridID string) {
+	checkboxService, err := h.gridService.GetGrid(context.Background(), gridID)
+	if err != nil {
+		log.Printf("Failed to load grid %q to resync its clients after a reset: %v", gridID, err)
+		return
+	}
+
+	h.mutex.Lock()
+	clients := make([]*wsClient, 0, len(h.clients[gridID]))
+	for _, client := range h.clients[gridID] {
+		clients = append(clients, client)
+	}
+	h.mutex.Unlock()
+
+	for _, client := range clients {
+		err := h.syncClient(client, nil, func(region models.Region) ([]wsFrame, int64, error) {
+			frames, version, err := h.readRegion(client, checkboxService, region)
+			if err != nil {
+				return nil, 0, err
+			}
+			notice, err := client.codec.notice(version, codeGridReset, "grid reset")
+			if err != nil {
+				return nil, 0, err
+			}
+			return append(notice, frames...), version, nil
+		})
+		if err != nil {
+			log.Printf("Failed to resync client %s after a reset of grid %q: %v", client.conn.RemoteAddr(), gridID, err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/usman-007/checkbox-backend/internal/store"
+)
+
+func TestChangeBatchAdd(t *testing.T) {
+	tests := []struct {
+		name      string
+		add       []store.Change
+		want      []store.Change
+		wantReset bool
+	}{
+		{
+			name: "distinct cells ordered by version",
+			add: []store.Change{
+				{Row: 1, Col: 1, Value: true, Version: 3},
+				{Row: 0, Col: 0, Value: true, Version: 1},
+				{Row: 0, Col: 1, Value: true, Version: 2},
+			},
+			want: []store.Change{
+				{Row: 0, Col: 0, Value: true, Version: 1},
+				{Row: 0, Col: 1, Value: true, Version: 2},
+				{Row: 1, Col: 1, Value: true, Version: 3},
+			},
+		},
+		{
+			name: "repeated cell keeps its latest change",
+			add: []store.Change{
+				{Row: 0, Col: 0, Value: true, Version: 1},
+				{Row: 2, Col: 2, Value: true, Version: 2},
+				{Row: 0, Col: 0, Value: false, Version: 3},
+				{Row: 0, Col: 0, Value: true, Version: 4},
+			},
+			want: []store.Change{
+				{Row: 2, Col: 2, Value: true, Version: 2},
+				{Row: 0, Col: 0, Value: true, Version: 4},
+			},
+		},
+		{
+			name: "older change arriving late is ignored",
+			add: []store.Change{
+				{Row: 0, Col: 0, Value: false, Version: 5},
+				{Row: 0, Col: 0, Value: true, Version: 4},
+			},
+			want: []store.Change{
+				{Row: 0, Col: 0, Value: false, Version: 5},
+			},
+		},
+		{
+			name: "reset discards earlier changes",
+			add: []store.Change{
+				{Row: 0, Col: 0, Value: true, Version: 1},
+				{Row: 1, Col: 0, Value: true, Version: 2},
+				{Version: 3, Reset: true},
+				{Row: 0, Col: 0, Value: true, Version: 4},
+			},
+			want: []store.Change{
+				{Row: 0, Col: 0, Value: true, Version: 4},
+			},
+			wantReset: true,
+		},
+		{
+			name: "reset keeps changes after it that arrived first",
+			add: []store.Change{
+				{Row: 0, Col: 0, Value: true, Version: 1},
+				{Row: 1, Col: 1, Value: true, Version: 4},
+				{Version: 3, Reset: true},
+				{Row: 1, Col: 1, Value: false, Version: 5},
+			},
+			want: []store.Change{
+				{Row: 1, Col: 1, Value: false, Version: 5},
+			},
+			wantReset: true,
+		},
+		{
+			name: "reset alone",
+			add: []store.Change{
+				{Version: 7, Reset: true},
+			},
+			wantReset: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batch := newChangeBatch()
+			for _, change := range tt.add {
+				batch.add(change)
+			}
+			if batch.empty() {
+				t.Fatal("batch is empty after adding changes")
+			}
+
+			changes, reset := batch.take()
+			if len(changes) == 0 {
+				changes = nil
+			}
+			if !reflect.DeepEqual(changes, tt.want) {
+				t.Errorf("changes = %+v, want %+v", changes, tt.want)
+			}
+			if reset != tt.wantReset {
+				t.Errorf("reset = %v, want %v", reset, tt.wantReset)
+			}
+			if !batch.empty() {
+				t.Error("batch is not empty after take")
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/usman-007/checkbox-backend/internal/auth"
+	"github.com/usman-007/checkbox-backend/internal/models"
+	"github.com/usman-007/checkbox-backend/internal/monitoring"
+	"github.com/usman-007/checkbox-backend/internal/ratelimit"
+	"github.com/usman-007/checkbox-backend/internal/services"
+	"github.com/usman-007/checkbox-backend/internal/store"
+)
+
+// Client message types
+const (
+	// clientMessageSet sets one checkbox: {"type":"set","row":1,"col":2,"value":true,"req_id":"7"}
+	// With "expected" it only applies if the checkbox holds that value.
+	clientMessageSet = "set"
+	// clientMessageToggle flips one checkbox: {"type":"toggle","row":1,"col":2,"req_id":"8"}
+	clientMessageToggle = "toggle"
+	// clientMessageSubscribe limits updates to a region, answered with a
+	// snapshot of it: {"type":"subscribe","region":{"row_start":0,"row_end":10,"col_start":0,"col_end":10}}.
+	// Leaving out region watches the whole grid again.
+	clientMessageSubscribe = "subscribe"
+)
+
+// clientMessage is a message sent by a WebSocket client. ReqID is chosen by
+// the client and echoed back in the matching ack or error.
+type clientMessage struct {
+	Type  string          `json:"type"`
+	ReqID json.RawMessage `json:"req_id,omitempty"`
+	Row   *int            `json:"row"`
+	Col   *int            `json:"col"`
+	Value *bool           `json:"value"`
+	// Expected makes a set conditional on the checkbox's current value
+	Expected *bool `json:"expected"`
+	// Region is the area a subscribe message asks for
+	Region *models.Region `json:"region"`
+}
+
+// ackMessage confirms a client request succeeded (legacy protocol). Value is
+// the checkbox's new value for requests that change one.
+type ackMessage struct {
+	Type    string          `json:"type"`
+	ReqID   json.RawMessage `json:"req_id,omitempty"`
+	Version int64           `json:"version"`
+	Value   *bool           `json:"value,omitempty"`
+}
+
+// errorMessage reports why a client request failed (legacy protocol). Value
+// is the checkbox's current value when a conditional set did not apply.
+type errorMessage struct {
+	Type  string          `json:"type"`
+	ReqID json.RawMessage `json:"req_id,omitempty"`
+	Error string          `json:"error"`
+	Value *bool           `json:"value,omitempty"`
+}
+
+// requestError is a failed client request with a machine-readable code.
+// value, when set, is the checkbox's current value.
+type requestError struct {
+	code    string
+	message string
+	value   *bool
+}
+
+// handleClientMessage applies a message read from a client and queues the
+// ack or error answering it
+func (h *WebSocketHandler) handleClientMessage(client *wsClient, checkboxService *services.CheckboxService, messageType int, data []byte) {
+	if messageType != websocket.TextMessage {
+		h.sendError(client, nil, codeInvalidMessage, "only text messages are supported", nil)
+		return
+	}
+
+	var msg clientMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		h.sendError(client, nil, codeInvalidMessage, "invalid message: "+err.Error(), nil)
+		return
+	}
+
+	switch msg.Type {
+	case clientMessageSet, clientMessageToggle:
+		change, err := h.applyCellUpdate(client, checkboxService, msg)
+		if err != nil {
+			h.sendError(client, msg.ReqID, err.code, err.message, err.value)
+			return
+		}
+		h.sendAck(client, msg.ReqID, change.Version, &change.Value)
+	case clientMessageSubscribe:
+		version, err := h.applySubscribe(client, checkboxService, msg)
+		if err != nil {
+			h.sendError(client, msg.ReqID, err.code, err.message, nil)
+			return
+		}
+		h.sendAck(client, msg.ReqID, version, nil)
+	default:
+		h.sendError(client, msg.ReqID, codeUnknownType, fmt.Sprintf("unknown message type %q", msg.Type), nil)
+	}
+}
+
+// applyCellUpdate validates and applies a "set" or "toggle" message,
+// returning the change it produced
+func (h *WebSocketHandler) applyCellUpdate(client *wsClient, checkboxService *services.CheckboxService, msg clientMessage) (store.Change, *requestError) {
+	// Viewers may watch the grid but not change it
+	if identity, _ := auth.FromContext(client.ctx); identity.Role < auth.RolePlayer {
+		return store.Change{}, &requestError{code: codeForbidden, message: "the player role is required to change checkboxes"}
+	}
+	if msg.Row == nil || msg.Col == nil {
+		return store.Change{}, &requestError{code: codeInvalidMessage, message: "row and col are required"}
+	}
+	if msg.Type == clientMessageSet && msg.Value == nil {
+		return store.Change{}, &requestError{code: codeInvalidMessage, message: "value is required"}
+	}
+	if err := h.takeToken(client); err != nil {
+		return store.Change{}, err
+	}
+	row, col := *msg.Row, *msg.Col
+	if !checkboxService.InBounds(row, col) {
+		err := fmt.Errorf("%w: (%d,%d) is not within %dx%d", services.ErrCellOutOfRange, row, col, checkboxService.Rows, checkboxService.Cols)
+		return store.Change{}, &requestError{code: codeOutOfRange, message: err.Error()}
+	}
+
+	ctx := client.ctx
+	var change store.Change
+	var err error
+	switch {
+	case msg.Type == clientMessageToggle:
+		change, err = checkboxService.ToggleCheckbox(ctx, row, col)
+	case msg.Expected != nil:
+		change, err = checkboxService.CompareAndSetCheckbox(ctx, row, col, *msg.Expected, *msg.Value)
+	default:
+		change, err = checkboxService.UpdateCheckboxState(ctx, row, col, *msg.Value)
+	}
+	if errors.Is(err, services.ErrValueMismatch) {
+		return store.Change{}, &requestError{code: codeValueMismatch, message: err.Error(), value: &change.Value}
+	}
+	if errors.Is(err, services.ErrCellOutOfRange) {
+		return store.Change{}, &requestError{code: codeOutOfRange, message: err.Error()}
+	}
+	if errors.Is(err, store.ErrGridNotFound) {
+		return store.Change{}, &requestError{code: codeGridDeleted, message: err.Error()}
+	}
+	if err != nil {
+		log.Printf("Failed to apply WebSocket update from client %s: %v", client.conn.RemoteAddr(), err)
+		return store.Change{}, &requestError{code: codeInternal, message: "failed to update checkbox state"}
+	}
+
+	// Record metrics for successful grid state update
+	monitoring.GridStateUpdates.Inc()
+	return change, nil
+}
+
+// takeToken charges a client update to the client's rate limit. Like the
+// HTTP middleware it lets the update through if the limiter fails.
+func (h *WebSocketHandler) takeToken(client *wsClient) *requestError {
+	allowed, wait, err := h.limiter.Allow(client.ctx, ratelimit.KeyFrom(client.ctx), 1)
+	if err != nil {
+		log.Printf("Rate limiter failed, allowing update from client %s: %v", client.conn.RemoteAddr(), err)
+		return nil
+	}
+	if !allowed {
+		monitoring.RateLimitRejections.WithLabelValues("websocket").Inc()
+		return &requestError{code: codeRateLimited, message: fmt.Sprintf("too many updates, retry in %s", wait.Round(time.Millisecond))}
+	}
+	return nil
+}
+
+// applySubscribe moves a client to the region of a "subscribe" message and
+// queues a snapshot of it, returning the snapshot's version
+func (h *WebSocketHandler) applySubscribe(client *wsClient, checkboxService *services.CheckboxService, msg clientMessage) (int64, *requestError) {
+	region := checkboxService.GetGrid().Region()
+	if msg.Region != nil {
+		region = *msg.Region
+	}
+	if err := checkboxService.ValidateRegion(region); err != nil {
+		return 0, &requestError{code: codeInvalidRegion, message: err.Error()}
+	}
+	if message, tooLarge := h.regionTooLarge(client.codec, region); tooLarge {
+		return 0, &requestError{code: codeRegionTooLarge, message: message}
+	}
+
+	version, err := h.sendRegion(client, checkboxService, region)
+	if err != nil {
+		log.Printf("Failed to send region snapshot to client %s: %v", client.conn.RemoteAddr(), err)
+		return 0, &requestError{code: codeInternal, message: "failed to read region"}
+	}
+	return version, nil
+}
+
+// sendAck queues an ack for a client request. value is the checkbox's new
+// value for requests that change one, otherwise nil.
+func (h *WebSocketHandler) sendAck(client *wsClient, reqID json.RawMessage, version int64, value *bool) {
+	frames, err := client.codec.ack(reqID, version, value)
+	h.send(client, frames, err)
+}
+
+// sendError queues an error answering a client request
+func (h *WebSocketHandler) sendError(client *wsClient, reqID json.RawMessage, code, message string, value *bool) {
+	frames, err := client.codec.error(reqID, code, message, value)
+	h.send(client, frames, err)
+}
+
+// send queues frames produced by the client's codec
+func (h *WebSocketHandler) send(client *wsClient, frames []wsFrame, err error) {
+	if err != nil {
+		log.Printf("Failed to encode message for client %s: %v", client.conn.RemoteAddr(), err)
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.clients[client.gridID][client.conn]; !ok {
+		return
+	}
+	for _, frame := range frames {
+		if !h.enqueueLocked(client.gridID, client, frame) {
+			return
+		}
+	}
+}
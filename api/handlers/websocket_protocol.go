@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"github.com/usman-007/checkbox-backend/internal/models"
+	"github.com/usman-007/checkbox-backend/internal/services"
+	"github.com/usman-007/checkbox-backend/internal/store"
+	"github.com/usman-007/checkbox-backend/pkg/binproto"
+)
+
+// JSONSubprotocol is the WebSocket subprotocol for the JSON envelope format.
+// Clients that do not negotiate a subprotocol get the legacy format.
+//
+// Every server message is an envelope:
+//
+//	{"type": "<type>", "version": <grid version>, "req_id": <echoed>, "payload": {...}}
+//
+// with these types and payloads:
+//
+//	snapshot  {"rows": 20, "cols": 20, "bitmap": "<base64>",
+//	           "region": {"row_start": 0, "row_end": 20, "col_start": 0, "col_end": 20}}
+//	          the cells of region (ends exclusive), the whole grid unless the
+//	          client subscribed to less; cell (row, col) is bit
+//	          (row-row_start)*(col_end-col_start)+col-col_start, counting from
+//	          the most significant bit of the first byte
+//	delta     {"changes": [{"row": 1, "column": 2, "value": true, "version": 42}]}
+//	          version is that of the last change; clients connecting with
+//	          meta=true also get each change's "meta": {"updated_at": "...",
+//	          "actor": "..."} when the grid keeps cell metadata
+//	ack       {} or {"value": true}: a client request succeeded; version is
+//	          the one it produced and value the checkbox's new value
+//	error     {"code": "out_of_range", "message": "..."}, with "value", the
+//	          checkbox's current value, when a conditional set did not apply
+//	notice    {"code": "resumed", "message": "..."}
+//	          informational, e.g. "resumed" when a reconnect is answered with
+//	          deltas instead of a snapshot, or "grid_reset" just before the
+//	          snapshot sent when the grid is reset
+//
+// req_id is only present on ack and error messages answering a client request.
+const JSONSubprotocol = "checkboxes.v1.json"
+
+// Envelope message types
+const (
+	envelopeSnapshot = "snapshot"
+	envelopeDelta    = "delta"
+	envelopeAck      = "ack"
+	envelopeError    = "error"
+	envelopeNotice   = "notice"
+)
+
+// Machine-readable codes carried by error and notice messages
+const (
+	codeInvalidMessage = "invalid_message"
+	codeUnknownType    = "unknown_type"
+	codeOutOfRange     = "out_of_range"
+	codeInvalidRegion  = "invalid_region"
+	codeValueMismatch  = "value_mismatch"
+	codeForbidden      = "forbidden"
+	codeRateLimited    = "rate_limited"
+	codeInternal       = "internal_error"
+	codeResumed        = "resumed"
+	codeGridDeleted    = "grid_deleted"
+	codeGridReset      = "grid_reset"
+)
+
+// wsCodec encodes server messages for one WebSocket subprotocol. A method
+// returns no frames for messages the protocol cannot express.
+type wsCodec interface {
+	// snapshot encodes the cells of region, whose bitmap is in snapshot
+	snapshot(grid models.Grid, region models.Region, snapshot store.Snapshot) ([]wsFrame, error)
+	changes(grid models.Grid, changes []store.Change) ([]wsFrame, error)
+	// ack and error carry a checkbox value when the request concerned one
+	ack(reqID json.RawMessage, version int64, value *bool) ([]wsFrame, error)
+	error(reqID json.RawMessage, code, message string, value *bool) ([]wsFrame, error)
+	notice(version int64, code, message string) ([]wsFrame, error)
+}
+
+var (
+	legacyProtocol wsCodec = legacyCodec{}
+	jsonProtocol   wsCodec = jsonCodec{}
+	binaryProtocol wsCodec = binaryCodec{}
+)
+
+// codecFor returns the codec for a negotiated subprotocol
+func codecFor(subprotocol string) wsCodec {
+	switch subprotocol {
+	case JSONSubprotocol:
+		return jsonProtocol
+	case binproto.Subprotocol:
+		return binaryProtocol
+	default:
+		return legacyProtocol
+	}
+}
+
+// legacyCodec speaks the original format: the snapshot is a JSON map keyed by
+// "states:(r,c)" and each change is a "(r,c):true" string. It carries no
+// versions or notices.
+type legacyCodec struct{}
+
+func (legacyCodec) snapshot(grid models.Grid, region models.Region, snapshot store.Snapshot) ([]wsFrame, error) {
+	return jsonFrames(services.CheckboxMap(region, snapshot.Bitmap))
+}
+
+func (legacyCodec) changes(grid models.Grid, changes []store.Change) ([]wsFrame, error) {
+	frames := make([]wsFrame, len(changes))
+	for i, change := range changes {
+		frames[i] = wsFrame{websocket.TextMessage, []byte(fmt.Sprintf("(%d,%d):%t", change.Row, change.Col, change.Value))}
+	}
+	return frames, nil
+}
+
+func (legacyCodec) ack(reqID json.RawMessage, version int64, value *bool) ([]wsFrame, error) {
+	return jsonFrames(ackMessage{Type: envelopeAck, ReqID: reqID, Version: version, Value: value})
+}
+
+func (legacyCodec) error(reqID json.RawMessage, code, message string, value *bool) ([]wsFrame, error) {
+	return jsonFrames(errorMessage{Type: envelopeError, ReqID: reqID, Error: message, Value: value})
+}
+
+func (legacyCodec) notice(version int64, code, message string) ([]wsFrame, error) {
+	return nil, nil
+}
+
+// envelope is a server message in the JSON protocol
+type envelope struct {
+	Type    string          `json:"type"`
+	Version int64           `json:"version"`
+	ReqID   json.RawMessage `json:"req_id,omitempty"`
+	Payload any             `json:"payload"`
+}
+
+type snapshotPayload struct {
+	Rows   int           `json:"rows"`
+	Cols   int           `json:"cols"`
+	Bitmap []byte        `json:"bitmap"`
+	Region models.Region `json:"region"`
+}
+
+type deltaPayload struct {
+	Changes []store.Change `json:"changes"`
+}
+
+type ackPayload struct {
+	Value *bool `json:"value,omitempty"`
+}
+
+type codePayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Value   *bool  `json:"value,omitempty"`
+}
+
+// jsonCodec speaks the JSONSubprotocol envelope format
+type jsonCodec struct{}
+
+func (jsonCodec) snapshot(grid models.Grid, region models.Region, snapshot store.Snapshot) ([]wsFrame, error) {
+	return jsonFrames(envelope{
+		Type:    envelopeSnapshot,
+		Version: snapshot.Version,
+		Payload: snapshotPayload{Rows: grid.Rows, Cols: grid.Cols, Bitmap: snapshot.Bitmap, Region: region},
+	})
+}
+
+func (jsonCodec) changes(grid models.Grid, changes []store.Change) ([]wsFrame, error) {
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return jsonFrames(envelope{
+		Type:    envelopeDelta,
+		Version: changes[len(changes)-1].Version,
+		Payload: deltaPayload{Changes: changes},
+	})
+}
+
+func (jsonCodec) ack(reqID json.RawMessage, version int64, value *bool) ([]wsFrame, error) {
+	return jsonFrames(envelope{Type: envelopeAck, Version: version, ReqID: reqID, Payload: ackPayload{Value: value}})
+}
+
+func (jsonCodec) error(reqID json.RawMessage, code, message string, value *bool) ([]wsFrame, error) {
+	return jsonFrames(envelope{Type: envelopeError, ReqID: reqID, Payload: codePayload{Code: code, Message: message, Value: value}})
+}
+
+func (jsonCodec) notice(version int64, code, message string) ([]wsFrame, error) {
+	return jsonFrames(envelope{Type: envelopeNotice, Version: version, Payload: codePayload{Code: code, Message: message}})
+}
+
+// binaryCodec speaks binproto.Subprotocol, the compact binary format
+// described in pkg/binproto
+type binaryCodec struct{}
+
+func (binaryCodec) snapshot(grid models.Grid, region models.Region, snapshot store.Snapshot) ([]wsFrame, error) {
+	var data []byte
+	var err error
+	if region == grid.Region() {
+		data, err = binproto.EncodeSnapshot(binproto.Snapshot{
+			Version: snapshot.Version,
+			Rows:    grid.Rows,
+			Cols:    grid.Cols,
+			Bitmap:  snapshot.Bitmap,
+		})
+	} else {
+		data, err = binproto.EncodeRegionSnapshot(binproto.RegionSnapshot{
+			Version: snapshot.Version,
+			Rows:    grid.Rows,
+			Cols:    grid.Cols,
+			Region:  binproto.Region(region),
+			Bitmap:  snapshot.Bitmap,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []wsFrame{{websocket.BinaryMessage, data}}, nil
+}
+
+func (binaryCodec) changes(grid models.Grid, changes []store.Change) ([]wsFrame, error) {
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	cells := make([]binproto.Cell, len(changes))
+	for i, change := range changes {
+		cells[i] = binproto.Cell{Index: uint32(change.Row*grid.Cols + change.Col), Value: change.Value}
+	}
+	data := binproto.EncodeDelta(binproto.Delta{Version: changes[len(changes)-1].Version, Cells: cells})
+	return []wsFrame{{websocket.BinaryMessage, data}}, nil
+}
+
+func (binaryCodec) ack(reqID json.RawMessage, version int64, value *bool) ([]wsFrame, error) {
+	data := binproto.EncodeAck(binproto.Ack{Version: version, ReqID: reqID, Value: value})
+	return []wsFrame{{websocket.BinaryMessage, data}}, nil
+}
+
+func (binaryCodec) error(reqID json.RawMessage, code, message string, value *bool) ([]wsFrame, error) {
+	data := binproto.EncodeError(binproto.Error{ReqID: reqID, Code: code, Message: message, Value: value})
+	return []wsFrame{{websocket.BinaryMessage, data}}, nil
+}
+
+func (binaryCodec) notice(version int64, code, message string) ([]wsFrame, error) {
+	data := binproto.EncodeNotice(binproto.Notice{Version: version, Code: code, Message: message})
+	return []wsFrame{{websocket.BinaryMessage, data}}, nil
+}
+
+// jsonFrames encodes v as a single text frame
+func jsonFrames(v any) ([]wsFrame, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return []wsFrame{{websocket.TextMessage, payload}}, nil
+}
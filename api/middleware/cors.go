@@ -1,14 +1,35 @@
 package middleware
 
-import "github.com/gin-gonic/gin"
+import (
+	"net/http"
 
-// CORS returns a middleware that handles CORS
-func CORS() gin.HandlerFunc {
+	"github.com/gin-gonic/gin"
+	"github.com/usman-007/checkbox-backend/internal/origin"
+)
+
+// CORS returns a middleware that handles CORS. Requests from an origin in
+// allowlist get it echoed back in Access-Control-Allow-Origin; requests from
+// any other browser origin are rejected.
+func CORS(allowlist *origin.Allowlist) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
+		requestOrigin := c.GetHeader("Origin")
+		if !allowlist.Allowed(requestOrigin, c.Request.Host) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "Origin not allowed",
+				"code":  "origin_not_allowed",
+			})
+			return
+		}
+
+		// Responses differ by origin, so caches must keep them apart
+		c.Writer.Header().Add("Vary", "Origin")
+		if requestOrigin != "" {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", requestOrigin)
+			c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+			c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-API-Key")
+			c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
+			c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Session-Token, X-Grid-Version, Retry-After")
+		}
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -17,4 +38,4 @@ func CORS() gin.HandlerFunc {
 
 		c.Next()
 	}
-} 
\ No newline at end of file
+}
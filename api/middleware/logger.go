@@ -1,7 +1,10 @@
 package middleware
 
 import (
+	"fmt"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -23,10 +26,17 @@ func Logger() gin.HandlerFunc {
 		method := c.Request.Method
 		path := c.Request.URL.Path
 
+		// Label metrics with the route pattern, such as /api/v1/grids/:id, so
+		// every grid and cell does not get series of its own
+		endpoint := c.FullPath()
+		if endpoint == "" {
+			endpoint = "unmatched"
+		}
+
 		// Record Prometheus metrics
 		statusStr := strconv.Itoa(status)
-		monitoring.HttpRequestsTotal.WithLabelValues(method, path, statusStr).Inc()
-		monitoring.HttpRequestDuration.WithLabelValues(method, path).Observe(latency.Seconds())
+		monitoring.HttpRequestsTotal.WithLabelValues(method, endpoint, statusStr).Inc()
+		monitoring.HttpRequestDuration.WithLabelValues(method, endpoint).Observe(latency.Seconds())
 
 		// Log using Gin's logger
 		gin.DefaultWriter.Write([]byte(
@@ -35,4 +45,39 @@ func Logger() gin.HandlerFunc {
 				"| " + string(rune(status)) + " | " +
 				latency.String() + "\n"))
 	}
-} 
\ No newline at end of file
+}
+
+// AccessLog returns gin's request logger with the credentials WebSocket
+// clients may pass in the query string redacted
+func AccessLog() gin.HandlerFunc {
+	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
+		return fmt.Sprintf("[GIN] %v |%3d| %13v | %15s |%-7s %#v\n%s",
+			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+			param.StatusCode,
+			param.Latency,
+			param.ClientIP,
+			param.Method,
+			redactQuery(param.Path),
+			param.ErrorMessage,
+		)
+	})
+}
+
+// redactQuery replaces the values of credential query parameters in path
+func redactQuery(path string) string {
+	base, rawQuery, ok := strings.Cut(path, "?")
+	if !ok {
+		return path
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		// Drop a query that cannot be parsed rather than risk logging it
+		return base + "?REDACTED"
+	}
+	for _, param := range []string{accessTokenParam, apiKeyParam} {
+		if query.Has(param) {
+			query.Set(param, "REDACTED")
+		}
+	}
+	return base + "?" + query.Encode()
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usman-007/checkbox-backend/internal/auth"
+	"github.com/usman-007/checkbox-backend/internal/monitoring"
+	"github.com/usman-007/checkbox-backend/internal/ratelimit"
+)
+
+// RateLimitKey returns a middleware that picks the key a request's updates
+// are rate limited under and puts it in the request context, where
+// ratelimit.KeyFrom finds it. Requests with credentials are keyed by their
+// identity and anonymous ones by client IP: sessions cost nothing to obtain,
+// so keying by them would let a client rotate through as many buckets as it
+// likes. It must run after Auth.
+func RateLimitKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := "ip:" + c.ClientIP()
+		if identity, _ := auth.FromContext(c.Request.Context()); !identity.Anonymous() {
+			key = identity.Subject
+		}
+		c.Request = c.Request.WithContext(ratelimit.NewContext(c.Request.Context(), key))
+		c.Next()
+	}
+}
+
+// RateLimit returns a middleware that charges each request one token with
+// TakeTokens
+func RateLimit(limiter ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if TakeTokens(c, limiter, 1) {
+			c.Next()
+		}
+	}
+}
+
+// TakeTokens takes cost tokens from the bucket of the request's key, set by
+// RateLimitKey, and reports whether it could. If not it answers 429 with a
+// Retry-After header, or 400 if cost is more than a bucket holds so waiting
+// would not help, and aborts the request. If the
+// limiter fails the request is let through, so an outage of its store does
+// not stop play.
+func TakeTokens(c *gin.Context, limiter ratelimit.Limiter, cost int) bool {
+	allowed, wait, err := limiter.Allow(c.Request.Context(), ratelimit.KeyFrom(c.Request.Context()), cost)
+	if errors.Is(err, ratelimit.ErrCostExceedsBurst) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Too many updates in one request: %v", err),
+			"code":  "invalid_request",
+		})
+		return false
+	}
+	if err != nil {
+		log.Printf("Rate limiter failed, allowing request: %v", err)
+		return true
+	}
+	if !allowed {
+		monitoring.RateLimitRejections.WithLabelValues("http").Inc()
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"error": "Too many updates, slow down",
+			"code":  "rate_limited",
+		})
+		return false
+	}
+	return true
+}
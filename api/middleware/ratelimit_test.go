@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usman-007/checkbox-backend/internal/auth"
+	"github.com/usman-007/checkbox-backend/internal/ratelimit"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestRateLimitKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		trusted  []string
+		remote   string
+		headers  map[string]string
+		identity auth.Identity
+		want     string
+	}{
+		{
+			name:   "anonymous keyed by remote address",
+			remote: "192.0.2.1:5000",
+			want:   "ip:192.0.2.1",
+		},
+		{
+			name:    "forged X-Forwarded-For ignored without trusted proxies",
+			remote:  "192.0.2.1:5000",
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.9"},
+			want:    "ip:192.0.2.1",
+		},
+		{
+			name:    "forged X-Real-IP ignored without trusted proxies",
+			remote:  "192.0.2.1:5000",
+			headers: map[string]string{"X-Real-IP": "203.0.113.9"},
+			want:    "ip:192.0.2.1",
+		},
+		{
+			name:    "forged header ignored from an untrusted address",
+			trusted: []string{"10.0.0.0/8"},
+			remote:  "192.0.2.1:5000",
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.9"},
+			want:    "ip:192.0.2.1",
+		},
+		{
+			name:    "header believed from a trusted proxy",
+			trusted: []string{"10.0.0.0/8"},
+			remote:  "10.1.2.3:5000",
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.9"},
+			want:    "ip:203.0.113.9",
+		},
+		{
+			name:     "user keyed by subject",
+			remote:   "192.0.2.1:5000",
+			identity: auth.Identity{Subject: "user:alice", Role: auth.RolePlayer},
+			want:     "user:alice",
+		},
+		{
+			name:     "API key keyed by name wherever it connects from",
+			remote:   "198.51.100.7:5000",
+			identity: auth.Identity{Subject: "apikey:dashboard", Role: auth.RoleViewer},
+			want:     "apikey:dashboard",
+		},
+		{
+			name:     "anonymous player keyed by IP",
+			remote:   "192.0.2.1:5000",
+			identity: auth.Identity{Role: auth.RolePlayer},
+			want:     "ip:192.0.2.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			if err := router.SetTrustedProxies(tt.trusted); err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			setIdentity := func(c *gin.Context) {
+				c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), tt.identity))
+			}
+			router.GET("/", setIdentity, RateLimitKey(), func(c *gin.Context) {
+				got = ratelimit.KeyFrom(c.Request.Context())
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/usman-007/checkbox-backend/internal/session"
+)
+
+const (
+	// SessionCookie is the cookie holding the session token
+	SessionCookie = "session"
+	// SessionTokenHeader carries a newly issued session token, for clients
+	// that do not keep cookies
+	SessionTokenHeader = "X-Session-Token"
+	// sessionScheme is the Authorization scheme for session tokens:
+	// "Authorization: Session <token>"
+	sessionScheme = "Session "
+)
+
+// Session returns a middleware that attaches an anonymous session to every
+// request. The token is read from the "Session" Authorization scheme or the
+// session cookie; requests without a valid one are issued a new session,
+// whose token is set as a cookie and returned in the X-Session-Token header.
+// The session is put in the request context, where session.FromContext
+// finds it.
+func Session(manager *session.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), sessionScheme)
+		if !ok {
+			token, _ = c.Cookie(SessionCookie)
+		}
+
+		s, err := manager.Verify(token)
+		if err != nil {
+			var newToken string
+			s, newToken, err = manager.Issue()
+			if err != nil {
+				log.Printf("Failed to issue session: %v", err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to issue session",
+					"code":  "internal_error",
+				})
+				return
+			}
+			c.SetSameSite(http.SameSiteLaxMode)
+			c.SetCookie(SessionCookie, newToken, int(manager.TTL().Seconds()), "/", "", c.Request.TLS != nil, true)
+			c.Header(SessionTokenHeader, newToken)
+		}
+
+		c.Request = c.Request.WithContext(session.NewContext(c.Request.Context(), s))
+		c.Next()
+	}
+}
@@ -4,57 +4,113 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/usman-007/checkbox-backend/api/handlers"
+	"github.com/usman-007/checkbox-backend/api/middleware"
+	"github.com/usman-007/checkbox-backend/config"
+	"github.com/usman-007/checkbox-backend/internal/auth"
+	"github.com/usman-007/checkbox-backend/internal/origin"
+	"github.com/usman-007/checkbox-backend/internal/ratelimit"
 	"github.com/usman-007/checkbox-backend/internal/redis"
 	"github.com/usman-007/checkbox-backend/internal/services"
+	"github.com/usman-007/checkbox-backend/internal/session"
 )
 
-// Setup configures all routes for the application
-func Setup(router *gin.Engine, redisClient *redis.Client) {
+// Setup configures all routes for the application.
+// redisClient may be nil when grids are kept in memory, in which case the
+// Redis maintenance routes are not registered. Every API request gets an
+// anonymous session from sessions and an identity from authenticator, and
+// each route declares the minimum role it requires. Checkbox updates, over
+// REST or WebSocket, and grid creations are rate limited by limiter, and WebSockets may only be
+// opened from origins in allowlist.
+func Setup(router *gin.Engine, cfg *config.Config, gridService *services.GridService, sessions *session.Manager, authenticator *auth.Authenticator, limiter ratelimit.Limiter, allowlist *origin.Allowlist, redisClient *redis.Client) {
 	// Health check
 	router.GET("/health", handlers.HealthCheck)
 
 	// Prometheus metrics endpoint
 	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
-	
-	// Initialize services
-	checkboxService := services.NewCheckboxService(redisClient.Client)
-	
+
 	// Initialize handlers
-	checkboxHandler := handlers.NewCheckboxHandler(checkboxService)
-	redisTestHandler := handlers.NewRedisTestHandler(redisClient)
-	websocketHandler := handlers.NewWebSocketHandler(checkboxService, redisClient.Client)
-	
-	// Start Redis subscription for WebSocket updates in a goroutine
-	go websocketHandler.StartRedisSubscription()
-	
+	gridHandler := handlers.NewGridHandler(gridService)
+	checkboxHandler := handlers.NewCheckboxHandler(gridService, cfg.Grid, limiter)
+	websocketHandler := handlers.NewWebSocketHandler(gridService, cfg.WebSocket, limiter, allowlist, cfg.Grid.MaxRegionCells)
+	adminHandler := handlers.NewAdminHandler(gridService)
+
+	viewer := middleware.RequireRole(auth.RoleViewer)
+	player := middleware.RequireRole(auth.RolePlayer)
+	admin := middleware.RequireRole(auth.RoleAdmin)
+	limit := middleware.RateLimit(limiter)
+
 	// API v1 routes
-	v1 := router.Group("/api/v1")
+	v1 := router.Group("/api/v1", middleware.Session(sessions), middleware.Auth(authenticator), middleware.RateLimitKey())
 	{
-		// redis routes
-		redis := v1.Group("/redis")
+		// Admin routes
+		adminGroup := v1.Group("/admin", admin)
+		{
+			adminGroup.POST("/grids/:id/reset", adminHandler.ResetGrid)
+			// redis routes
+			if redisClient != nil {
+				redisTestHandler := handlers.NewRedisTestHandler(redisClient)
+				adminGroup.GET("/redis", redisTestHandler.TestRedis)
+			}
+		}
+
+		// Grid management routes
+		grids := v1.Group("/grids")
 		{
-			redis.DELETE("", redisTestHandler.ClearRedis)
-			redis.GET("", redisTestHandler.TestRedis)
+			grids.GET("", viewer, gridHandler.ListGrids)
+			grids.POST("", player, limit, gridHandler.CreateGrid)
+			grids.GET("/:id", viewer, gridHandler.GetGrid)
+			grids.DELETE("/:id", admin, gridHandler.DeleteGrid)
+			grids.GET("/:id/checkbox", viewer, checkboxHandler.GetAllCheckboxes)
+			grids.GET("/:id/checkbox/:row/:col", viewer, checkboxHandler.GetCheckbox)
+			grids.PATCH("/:id/checkbox", player, limit, checkboxHandler.UpdateCheckbox)
+			// Batches are charged one token per operation by the handler
+			grids.POST("/:id/checkbox/batch", player, checkboxHandler.UpdateCheckboxes)
+			grids.POST("/:id/checkbox/toggle", player, limit, checkboxHandler.ToggleCheckbox)
+			// Viewers may watch; changes sent over the socket need the player role
+			grids.GET("/:id/ws", viewer, websocketHandler.HandleWebSocket)
 		}
-		
-		// Checkbox routes
+
+		// Default grid metadata
+		v1.GET("/grid", viewer, gridHandler.GetGrid)
+
+		// Checkbox routes for the default grid
 		checkbox := v1.Group("/checkbox")
 		{
-			checkbox.GET("", checkboxHandler.GetAllCheckboxes)
-			checkbox.PATCH("", checkboxHandler.UpdateCheckbox)
+			checkbox.GET("", viewer, checkboxHandler.GetAllCheckboxes)
+			checkbox.GET("/:row/:col", viewer, checkboxHandler.GetCheckbox)
+			checkbox.PATCH("", player, limit, checkboxHandler.UpdateCheckbox)
+			checkbox.POST("/batch", player, checkboxHandler.UpdateCheckboxes)
+			checkbox.POST("/toggle", player, limit, checkboxHandler.ToggleCheckbox)
 		}
-		
-		// WebSocket endpoint in API v1
-		v1.GET("/ws", websocketHandler.HandleWebSocket)
+
+		// WebSocket endpoint for the default grid
+		v1.GET("/ws", viewer, websocketHandler.HandleWebSocket)
 	}
 }
 
 /*
-redis 
-curl http://localhost:8080/api/v1/redis // TEST REDIS
-curl -X DELETE http://localhost:8080/api/v1/redis // CLEAR REDIS
+redis
+curl -H "X-API-Key: $ADMIN_TOKEN" http://localhost:8080/api/v1/admin/redis // TEST REDIS
+curl -X POST -H "Authorization: Bearer $ADMIN_TOKEN" http://localhost:8080/api/v1/admin/grids/default/reset // RESET A GRID
+
+grids
+curl http://localhost:8080/api/v1/grids // LIST GRIDS
+curl -X POST http://localhost:8080/api/v1/grids -d '{"id":"lobby","rows":50,"cols":50}' // CREATE GRID
+curl http://localhost:8080/api/v1/grids/lobby // GET GRID
+curl -X DELETE -H "Authorization: Bearer $ADMIN_TOKEN" http://localhost:8080/api/v1/grids/lobby // DELETE GRID
+curl http://localhost:8080/api/v1/grids/lobby/checkbox // GET ALL CHECKBOXES OF A GRID
+ws://localhost:8080/api/v1/grids/lobby/ws // WEBSOCKET FOR A GRID
+ws://localhost:8080/api/v1/grids/lobby/ws?access_token=$JWT // WEBSOCKET WITH A JWT
+
+grid
+curl http://localhost:8080/api/v1/grid // GET DEFAULT GRID DIMENSIONS
 
 checkbox
 curl http://localhost:8080/api/v1/checkbox // GET ALL CHECKBOXES
-curl -X PATCH http://localhost:8080/api/v1/checkbox?row=1$column=2&value=true
+curl "http://localhost:8080/api/v1/checkbox?row_start=0&row_end=10&col_start=0&col_end=10&format=bits" // GET A REGION AS A BITMAP
+curl http://localhost:8080/api/v1/checkbox/1/2 // GET ONE CHECKBOX, WITH WHO CHANGED IT AND WHEN
+curl -X PATCH http://localhost:8080/api/v1/checkbox -H 'Content-Type: application/json' -d '{"row":1,"column":2,"value":true}' // UPDATE A CHECKBOX
+curl -X PATCH http://localhost:8080/api/v1/checkbox -H 'Content-Type: application/json' -d '{"row":1,"column":2,"value":true,"expected":false}' // SET ONLY IF CURRENTLY UNCHECKED
+curl -X POST http://localhost:8080/api/v1/checkbox/toggle -H 'Content-Type: application/json' -d '{"row":1,"column":2}' // TOGGLE A CHECKBOX
+curl -X POST http://localhost:8080/api/v1/checkbox/batch -d '[{"row":1,"column":2,"value":true},{"row":1,"column":3,"value":true}]' // UPDATE MANY CHECKBOXES
 */
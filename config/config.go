@@ -1,14 +1,36 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// MaxGridCells caps the number of cells in any grid. Whole-grid snapshots
+// are then at most 128 KiB bitmaps, which the server can read and send.
+const MaxGridCells = 1 << 20
+
 // Config holds all configuration for the application
 type Config struct {
 	Environment   string
 	ServerAddress string
 	Redis         RedisConfig
+	// StoreBackend selects where grid state lives: "redis" or "memory"
+	StoreBackend string
+	// AllowedOrigins are the browser origins, besides the server's own, that
+	// may call the API and open WebSockets. See origin.NewAllowlist.
+	AllowedOrigins []string
+	// TrustedProxies are the addresses or CIDRs of proxies whose
+	// X-Forwarded-For and X-Real-IP headers give the client IP. With none,
+	// the client IP is the connection's remote address.
+	TrustedProxies []string
+	Grid           GridConfig
+	WebSocket      WebSocketConfig
+	Session        SessionConfig
+	Auth           AuthConfig
+	RateLimit      RateLimitConfig
 	// Add more configuration fields as needed (database, etc.)
 }
 
@@ -19,6 +41,86 @@ type RedisConfig struct {
 	DB       int
 }
 
+// GridConfig holds the dimensions of the checkbox grid
+type GridConfig struct {
+	Rows int
+	Cols int
+	// ResetOnStart clears any stored grid at startup instead of reusing it
+	ResetOnStart bool
+	// ChangeLogSize is how many recent changes each grid keeps so
+	// reconnecting clients can catch up without a full snapshot
+	ChangeLogSize int
+	// MaxRegionCells caps how many cells one GET of checkboxes may return
+	MaxRegionCells int
+	// CellMetadata records when and by whom each cell was last changed
+	CellMetadata bool
+	// MaxCount caps how many grids, the default one included, may exist
+	MaxCount int
+}
+
+// SessionConfig holds settings for anonymous player sessions
+type SessionConfig struct {
+	// Secret signs session tokens. When empty a random secret is used,
+	// so sessions end on restart and are not shared between instances.
+	Secret string
+	// TTL is how long a session token stays valid
+	TTL time.Duration
+}
+
+// AuthConfig holds the credentials accepted by the API and the roles they grant
+type AuthConfig struct {
+	// JWTSecret verifies HS256-signed JWTs. JWTs are rejected when it is empty.
+	JWTSecret string
+	// APIKeys are the static keys accepted by the API
+	APIKeys []APIKeyConfig
+	// AnonymousRole is the role of requests presenting no credentials
+	AnonymousRole string
+}
+
+// APIKeyConfig is a static API key and the role it grants
+type APIKeyConfig struct {
+	Name string
+	Role string
+	Key  string
+}
+
+// RateLimitConfig holds the token bucket limiting each client's checkbox updates
+type RateLimitConfig struct {
+	// Rate is how many updates per second a client may sustain
+	Rate int
+	// Burst is how many updates a client may make at once after being idle
+	Burst int
+}
+
+// Slow client policies for WebSocketConfig.SlowClientPolicy
+const (
+	// SlowClientDisconnect closes connections whose send queue is full
+	SlowClientDisconnect = "disconnect"
+	// SlowClientDrop discards messages for connections whose send queue is full
+	SlowClientDrop = "drop"
+)
+
+// WebSocketConfig holds WebSocket connection settings
+type WebSocketConfig struct {
+	// SendQueueSize is how many outgoing messages may wait for a slow connection
+	SendQueueSize int
+	// SlowClientPolicy says what happens when a connection's queue is full
+	SlowClientPolicy string
+	// PingInterval is how often the server pings each connection
+	PingInterval time.Duration
+	// PongTimeout is how long a connection may stay silent, pongs included,
+	// before it is considered dead. It must be longer than PingInterval.
+	PongTimeout time.Duration
+	// WriteTimeout bounds every write to a connection
+	WriteTimeout time.Duration
+	// BatchInterval is how long changes are collected before they are
+	// broadcast together as one delta
+	BatchInterval time.Duration
+	// MaxMessageSize is the largest message, in bytes, a client may send.
+	// Connections sending larger ones are closed.
+	MaxMessageSize int
+}
+
 // Load loads configuration from environment variables
 func Load() (*Config, error) {
 	env := os.Getenv("APP_ENV")
@@ -36,17 +138,215 @@ func Load() (*Config, error) {
 		redisAddr = "localhost:6379"
 	}
 
+	storeBackend := os.Getenv("STORE_BACKEND")
+	if storeBackend == "" {
+		storeBackend = "redis"
+	}
+	if storeBackend != "redis" && storeBackend != "memory" {
+		return nil, fmt.Errorf("invalid STORE_BACKEND %q: must be 'redis' or 'memory'", storeBackend)
+	}
+
+	gridRows, err := positiveIntEnv("GRID_ROWS", 20)
+	if err != nil {
+		return nil, err
+	}
+	gridCols, err := positiveIntEnv("GRID_COLS", 20)
+	if err != nil {
+		return nil, err
+	}
+	// Divide rather than multiply so huge dimensions cannot overflow past the check
+	if gridRows > MaxGridCells/gridCols {
+		return nil, fmt.Errorf("GRID_ROWS*GRID_COLS (%d*%d) must be at most %d cells", gridRows, gridCols, MaxGridCells)
+	}
+
+	resetOnStart := os.Getenv("GRID_RESET_ON_START") == "true"
+	cellMetadata := os.Getenv("GRID_CELL_METADATA") == "true"
+
+	changeLogSize, err := positiveIntEnv("GRID_CHANGE_LOG_SIZE", 1000)
+	if err != nil {
+		return nil, err
+	}
+	maxRegionCells, err := positiveIntEnv("GRID_MAX_REGION_CELLS", 65536)
+	if err != nil {
+		return nil, err
+	}
+	maxGridCount, err := positiveIntEnv("GRID_MAX_COUNT", 100)
+	if err != nil {
+		return nil, err
+	}
+
+	sendQueueSize, err := positiveIntEnv("WS_SEND_QUEUE_SIZE", 256)
+	if err != nil {
+		return nil, err
+	}
+
+	slowClientPolicy := os.Getenv("WS_SLOW_CLIENT_POLICY")
+	if slowClientPolicy == "" {
+		slowClientPolicy = SlowClientDisconnect
+	}
+	if slowClientPolicy != SlowClientDisconnect && slowClientPolicy != SlowClientDrop {
+		return nil, fmt.Errorf("invalid WS_SLOW_CLIENT_POLICY %q: must be '%s' or '%s'", slowClientPolicy, SlowClientDisconnect, SlowClientDrop)
+	}
+
+	pingInterval, err := durationEnv("WS_PING_INTERVAL", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	pongTimeout, err := durationEnv("WS_PONG_TIMEOUT", 60*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if pongTimeout <= pingInterval {
+		return nil, fmt.Errorf("WS_PONG_TIMEOUT (%s) must be longer than WS_PING_INTERVAL (%s)", pongTimeout, pingInterval)
+	}
+	writeTimeout, err := durationEnv("WS_WRITE_TIMEOUT", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	batchInterval, err := durationEnv("WS_BATCH_INTERVAL", 50*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	maxMessageSize, err := positiveIntEnv("WS_MAX_MESSAGE_SIZE", 4096)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionSecret := os.Getenv("SESSION_SECRET")
+	sessionTTL, err := durationEnv("SESSION_TTL", 30*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys, err := apiKeysEnv("AUTH_API_KEYS")
+	if err != nil {
+		return nil, err
+	}
+	// ADMIN_TOKEN is shorthand for an API key named "admin" with the admin role
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		apiKeys = append(apiKeys, APIKeyConfig{Name: "admin", Role: "admin", Key: adminToken})
+	}
+	anonymousRole := os.Getenv("AUTH_ANONYMOUS_ROLE")
+	if anonymousRole == "" {
+		anonymousRole = "player"
+	}
+
+	rateLimitRate, err := positiveIntEnv("RATE_LIMIT_RATE", 10)
+	if err != nil {
+		return nil, err
+	}
+	rateLimitBurst, err := positiveIntEnv("RATE_LIMIT_BURST", 20)
+	if err != nil {
+		return nil, err
+	}
+
+	allowedOrigins := listEnv("ALLOWED_ORIGINS")
+	trustedProxies := listEnv("TRUSTED_PROXIES")
+
 	redisPassword := os.Getenv("REDIS_PASSWORD")
 	// Redis DB is typically a number from 0-15
 	redisDB := 0
 
 	return &Config{
-		Environment:   env,
-		ServerAddress: addr,
+		Environment:    env,
+		ServerAddress:  addr,
+		StoreBackend:   storeBackend,
+		AllowedOrigins: allowedOrigins,
+		TrustedProxies: trustedProxies,
+		Grid: GridConfig{
+			Rows:           gridRows,
+			Cols:           gridCols,
+			ResetOnStart:   resetOnStart,
+			ChangeLogSize:  changeLogSize,
+			MaxRegionCells: maxRegionCells,
+			CellMetadata:   cellMetadata,
+			MaxCount:       maxGridCount,
+		},
+		WebSocket: WebSocketConfig{
+			SendQueueSize:    sendQueueSize,
+			SlowClientPolicy: slowClientPolicy,
+			PingInterval:     pingInterval,
+			PongTimeout:      pongTimeout,
+			WriteTimeout:     writeTimeout,
+			BatchInterval:    batchInterval,
+			MaxMessageSize:   maxMessageSize,
+		},
+		Session: SessionConfig{
+			Secret: sessionSecret,
+			TTL:    sessionTTL,
+		},
+		Auth: AuthConfig{
+			JWTSecret:     os.Getenv("AUTH_JWT_SECRET"),
+			APIKeys:       apiKeys,
+			AnonymousRole: anonymousRole,
+		},
+		RateLimit: RateLimitConfig{
+			Rate:  rateLimitRate,
+			Burst: rateLimitBurst,
+		},
 		Redis: RedisConfig{
 			Address:  redisAddr,
 			Password: redisPassword,
 			DB:       redisDB,
 		},
 	}, nil
-} 
\ No newline at end of file
+}
+
+// positiveIntEnv reads a positive integer from the environment variable key,
+// returning def when it is unset
+func positiveIntEnv(key string, def int) (int, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive integer", key, raw)
+	}
+	return value, nil
+}
+
+// durationEnv reads a positive duration such as "30s" from the environment
+// variable key, returning def when it is unset
+func durationEnv(key string, def time.Duration) (time.Duration, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def, nil
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive duration such as 30s", key, raw)
+	}
+	return value, nil
+}
+
+// listEnv reads a comma-separated list from the environment variable key,
+// skipping empty entries
+func listEnv(key string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// apiKeysEnv reads API keys from the environment variable key as a
+// comma-separated list of "name:role:key" entries
+func apiKeysEnv(key string) ([]APIKeyConfig, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return nil, nil
+	}
+	var keys []APIKeyConfig
+	for i, entry := range strings.Split(raw, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			// The entry is not quoted so a malformed key does not end up in logs
+			return nil, fmt.Errorf("invalid %s entry %d: must be name:role:key", key, i+1)
+		}
+		keys = append(keys, APIKeyConfig{Name: parts[0], Role: parts[1], Key: parts[2]})
+	}
+	return keys, nil
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidCredentials is returned for a JWT or API key that is not accepted
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Role is what an identity may do. Roles are ordered: each one may do
+// everything the roles below it may.
+type Role int
+
+// Roles, from least to most privileged
+const (
+	// RoleNone may only reach public routes
+	RoleNone Role = iota
+	// RoleViewer may read grids and watch them over WebSocket
+	RoleViewer
+	// RolePlayer may also change checkboxes and create grids
+	RolePlayer
+	// RoleAdmin may also delete and reset grids
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RoleNone:   "none",
+	RoleViewer: "viewer",
+	RolePlayer: "player",
+	RoleAdmin:  "admin",
+}
+
+// ParseRole returns the role with the given name
+func ParseRole(name string) (Role, error) {
+	for role, n := range roleNames {
+		if n == name {
+			return role, nil
+		}
+	}
+	return RoleNone, fmt.Errorf("unknown role %q: must be none, viewer, player or admin", name)
+}
+
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+// Identity is who a request comes from
+type Identity struct {
+	// Subject names the user, as "user:<sub>", or the API key, as
+	// "apikey:<name>". It is empty for anonymous requests.
+	Subject string
+	Role    Role
+}
+
+// Anonymous reports whether the identity presented no credentials
+func (i Identity) Anonymous() bool {
+	return i.Subject == ""
+}
+
+// APIKey is a static key granting a role
+type APIKey struct {
+	Name string
+	Role Role
+	Key  string
+}
+
+// Authenticator checks the credentials presented with a request
+type Authenticator struct {
+	jwtSecret []byte
+	apiKeys   map[[sha256.Size]byte]APIKey
+	anonymous Role
+}
+
+// NewAuthenticator creates an authenticator accepting JWTs signed with
+// jwtSecret, if it is not empty, and the given API keys. Requests without
+// credentials get the anonymous role.
+func NewAuthenticator(jwtSecret []byte, apiKeys []APIKey, anonymous Role) *Authenticator {
+	// Keys are looked up by hash so the comparison does not leak their contents
+	byHash := make(map[[sha256.Size]byte]APIKey, len(apiKeys))
+	for _, key := range apiKeys {
+		byHash[sha256.Sum256([]byte(key.Key))] = key
+	}
+	return &Authenticator{
+		jwtSecret: jwtSecret,
+		apiKeys:   byHash,
+		anonymous: anonymous,
+	}
+}
+
+// Authenticate returns the identity for a bearer token, which may be a JWT
+// or an API key, or an API key given on its own. With neither the identity
+// is anonymous.
+func (a *Authenticator) Authenticate(bearer, apiKey string) (Identity, error) {
+	switch {
+	case bearer != "" && strings.Count(bearer, ".") == 2:
+		if len(a.jwtSecret) == 0 {
+			return Identity{}, fmt.Errorf("%w: JWTs are not accepted", ErrInvalidCredentials)
+		}
+		return VerifyJWT(bearer, a.jwtSecret)
+	case bearer != "":
+		return a.lookupKey(bearer)
+	case apiKey != "":
+		return a.lookupKey(apiKey)
+	}
+	return Identity{Role: a.anonymous}, nil
+}
+
+func (a *Authenticator) lookupKey(key string) (Identity, error) {
+	k, ok := a.apiKeys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return Identity{}, fmt.Errorf("%w: unknown API key", ErrInvalidCredentials)
+	}
+	return Identity{Subject: "apikey:" + k.Name, Role: k.Role}, nil
+}
+
+// contextKey is the context key of the request's identity
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying identity
+func NewContext(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+// FromContext returns the identity carried by ctx, if any
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(contextKey{}).(Identity)
+	return identity, ok
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// jwtHeader is the JOSE header of a JWT
+type jwtHeader struct {
+	Alg string `json:"alg"`
+}
+
+// jwtClaims are the claims read from a JWT. Times are Unix seconds.
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	Role      string `json:"role"`
+	ExpiresAt *int64 `json:"exp"`
+	NotBefore *int64 `json:"nbf"`
+}
+
+// VerifyJWT checks an HS256-signed JWT against secret and returns the
+// identity it grants. The token must carry a "sub" and a "role" claim; "exp"
+// and "nbf" are enforced when present.
+func VerifyJWT(token string, secret []byte) (Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Identity{}, fmt.Errorf("%w: malformed JWT", ErrInvalidCredentials)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Identity{}, err
+	}
+	// Only HS256 is accepted, which also rules out unsigned "none" tokens
+	if header.Alg != "HS256" {
+		return Identity{}, fmt.Errorf("%w: unsupported JWT algorithm %q", ErrInvalidCredentials, header.Alg)
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return Identity{}, fmt.Errorf("%w: bad JWT signature", ErrInvalidCredentials)
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Identity{}, err
+	}
+	now := time.Now().Unix()
+	if claims.ExpiresAt != nil && now >= *claims.ExpiresAt {
+		return Identity{}, fmt.Errorf("%w: JWT expired", ErrInvalidCredentials)
+	}
+	if claims.NotBefore != nil && now < *claims.NotBefore {
+		return Identity{}, fmt.Errorf("%w: JWT not valid yet", ErrInvalidCredentials)
+	}
+	if claims.Subject == "" {
+		return Identity{}, fmt.Errorf("%w: JWT has no sub claim", ErrInvalidCredentials)
+	}
+	role, err := ParseRole(claims.Role)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	return Identity{Subject: "user:" + claims.Subject, Role: role}, nil
+}
+
+// decodeSegment decodes one base64url JSON segment of a JWT into v
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: malformed JWT", ErrInvalidCredentials)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: malformed JWT", ErrInvalidCredentials)
+	}
+	return nil
+}
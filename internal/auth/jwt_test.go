@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// makeJWT builds a JWT from raw header and claims JSON, signed with secret
+func makeJWT(header, claims string, secret []byte) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyJWT(t *testing.T) {
+	secret := []byte("secret")
+	hs256 := `{"alg":"HS256","typ":"JWT"}`
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	valid := makeJWT(hs256, `{"sub":"alice","role":"player"}`, secret)
+
+	tests := []struct {
+		name    string
+		token   string
+		want    Identity
+		wantErr bool
+	}{
+		{name: "valid", token: valid, want: Identity{Subject: "user:alice", Role: RolePlayer}},
+		{
+			name:  "valid with exp and nbf",
+			token: makeJWT(hs256, `{"sub":"bob","role":"admin","exp":`+future+`,"nbf":`+past+`}`, secret),
+			want:  Identity{Subject: "user:bob", Role: RoleAdmin},
+		},
+		{name: "viewer role", token: makeJWT(hs256, `{"sub":"carol","role":"viewer"}`, secret), want: Identity{Subject: "user:carol", Role: RoleViewer}},
+		{name: "expired", token: makeJWT(hs256, `{"sub":"alice","role":"player","exp":`+past+`}`, secret), wantErr: true},
+		{name: "not valid yet", token: makeJWT(hs256, `{"sub":"alice","role":"player","nbf":`+future+`}`, secret), wantErr: true},
+		{name: "wrong secret", token: makeJWT(hs256, `{"sub":"alice","role":"player"}`, []byte("other")), wantErr: true},
+		{name: "tampered claims", token: tamper(valid, `{"sub":"alice","role":"admin"}`), wantErr: true},
+		{name: "alg none", token: makeJWT(`{"alg":"none"}`, `{"sub":"alice","role":"admin"}`, secret), wantErr: true},
+		{name: "alg HS512", token: makeJWT(`{"alg":"HS512"}`, `{"sub":"alice","role":"admin"}`, secret), wantErr: true},
+		{name: "missing sub", token: makeJWT(hs256, `{"role":"player"}`, secret), wantErr: true},
+		{name: "missing role", token: makeJWT(hs256, `{"sub":"alice"}`, secret), wantErr: true},
+		{name: "unknown role", token: makeJWT(hs256, `{"sub":"alice","role":"owner"}`, secret), wantErr: true},
+		{name: "two segments", token: "a.b", wantErr: true},
+		{name: "bad base64 header", token: "!!!" + valid[strings.Index(valid, "."):], wantErr: true},
+		{name: "header not JSON", token: makeJWT("HS256", `{"sub":"alice","role":"player"}`, secret), wantErr: true},
+		{name: "claims not JSON", token: makeJWT(hs256, `alice`, secret), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := VerifyJWT(tt.token, secret)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidCredentials) {
+					t.Errorf("VerifyJWT error = %v, want ErrInvalidCredentials", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyJWT: %v", err)
+			}
+			if identity != tt.want {
+				t.Errorf("VerifyJWT = %+v, want %+v", identity, tt.want)
+			}
+		})
+	}
+}
+
+// tamper replaces the claims of token, keeping its header and signature
+func tamper(token, claims string) string {
+	parts := strings.Split(token, ".")
+	return parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + "." + parts[2]
+}
@@ -2,9 +2,21 @@ package models
 
 import "time"
 
-// User represents a user in the system
+// Checkbox names a checkbox by row and column and, in an update, the value to
+// give it. The fields are pointers so missing ones can be told from zeros.
+// When a checkbox is read, UpdatedAt and Actor say when and by whom it was
+// last changed, if the grid keeps cell metadata.
 type Checkbox struct {
-	RowID     uint32 `json:"row_id"`
-	ColumnID uint32 `json:"column_id"`
-	UpdatedAt time.Time `json:"updated_at"`
+	Row       *int       `json:"row" form:"row"`
+	Column    *int       `json:"column" form:"column"`
+	Value     *bool      `json:"value,omitempty" form:"value"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty" form:"-"`
+	Actor     string     `json:"actor,omitempty" form:"-"`
+}
+
+// CheckboxUpdate is a request to set a checkbox. With Expected set the
+// update only applies if the checkbox currently holds that value.
+type CheckboxUpdate struct {
+	Checkbox
+	Expected *bool `json:"expected,omitempty" form:"expected"`
 }
@@ -0,0 +1,37 @@
+package models
+
+// Grid describes a checkbox grid
+type Grid struct {
+	ID   string `json:"id"`
+	Rows int    `json:"rows"`
+	Cols int    `json:"cols"`
+}
+
+// Region is a rectangle of cells: rows RowStart up to but not including
+// RowEnd, and columns ColStart up to but not including ColEnd
+type Region struct {
+	RowStart int `json:"row_start"`
+	RowEnd   int `json:"row_end"`
+	ColStart int `json:"col_start"`
+	ColEnd   int `json:"col_end"`
+}
+
+// Region returns the region covering the whole grid
+func (g Grid) Region() Region {
+	return Region{RowEnd: g.Rows, ColEnd: g.Cols}
+}
+
+// Rows returns the region's height
+func (r Region) Rows() int {
+	return r.RowEnd - r.RowStart
+}
+
+// Cols returns the region's width
+func (r Region) Cols() int {
+	return r.ColEnd - r.ColStart
+}
+
+// Contains reports whether (row, col) lies in the region
+func (r Region) Contains(row, col int) bool {
+	return row >= r.RowStart && row < r.RowEnd && col >= r.ColStart && col < r.ColEnd
+}
@@ -40,6 +40,45 @@ var (
 		[]string{"direction"},
 	)
 
+	WebSocketEvictions = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "websocket_slow_client_evictions_total",
+			Help: "Total number of WebSocket clients disconnected because their send queue was full",
+		},
+	)
+
+	WebSocketDroppedMessages = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "websocket_dropped_messages_total",
+			Help: "Total number of WebSocket messages dropped because the client's send queue was full",
+		},
+	)
+
+	WebSocketBatchSize = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "websocket_batch_size",
+			Help:    "Number of cell changes in each broadcast batch after repeated changes are collapsed",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+		},
+	)
+
+	WebSocketFlushLatency = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "websocket_batch_flush_latency_seconds",
+			Help:    "Time from the first change of a broadcast batch arriving to the batch being queued for every client",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+
+	// Rate limiting metrics
+	RateLimitRejections = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rate_limit_rejected_requests_total",
+			Help: "Total number of checkbox updates rejected by the rate limiter",
+		},
+		[]string{"transport"},
+	)
+
 	// Application metrics
 	GridStateUpdates = promauto.NewCounter(
 		prometheus.CounterOpts{
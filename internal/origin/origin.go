@@ -0,0 +1,81 @@
+package origin
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// pattern is one allowlist entry. host, including any port, is either the
+// exact host allowed or, when wildcard is set, the domain whose subdomains are.
+type pattern struct {
+	scheme   string
+	host     string
+	wildcard bool
+}
+
+// Allowlist holds the browser origins allowed to call the API and open
+// WebSockets
+type Allowlist struct {
+	patterns []pattern
+}
+
+// NewAllowlist parses origins such as "https://example.com" or
+// "http://localhost:3000". A host starting with "*." allows every subdomain
+// of the rest, but not the domain itself: "https://*.example.com" allows
+// "https://play.example.com".
+func NewAllowlist(origins []string) (*Allowlist, error) {
+	patterns := make([]pattern, 0, len(origins))
+	for _, o := range origins {
+		scheme, host, ok := strings.Cut(strings.ToLower(o), "://")
+		if !ok || scheme == "" || host == "" || strings.ContainsAny(host, "/?#") {
+			return nil, fmt.Errorf("invalid origin %q: must be scheme://host[:port]", o)
+		}
+		p := pattern{scheme: scheme, host: host}
+		if rest, ok := strings.CutPrefix(host, "*."); ok {
+			if rest == "" || strings.Contains(rest, "*") {
+				return nil, fmt.Errorf("invalid origin %q: a wildcard needs a domain, as in https://*.example.com", o)
+			}
+			p.host, p.wildcard = rest, true
+		} else if strings.Contains(host, "*") {
+			return nil, fmt.Errorf("invalid origin %q: a wildcard may only start the host", o)
+		}
+		patterns = append(patterns, p)
+	}
+	return &Allowlist{patterns: patterns}, nil
+}
+
+// Allowed reports whether a request from origin, the value of its Origin
+// header, to host, its Host header, may proceed. Requests without an Origin
+// do not come from a browser page and are allowed, as are same-origin ones.
+func (a *Allowlist) Allowed(origin, host string) bool {
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+	if u.Host == strings.ToLower(host) {
+		return true
+	}
+	for _, p := range a.patterns {
+		if p.matches(u.Scheme, u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether an origin's scheme and host, including any port,
+// match the pattern
+func (p pattern) matches(scheme, host string) bool {
+	if scheme != p.scheme {
+		return false
+	}
+	if !p.wildcard {
+		return host == p.host
+	}
+	sub, ok := strings.CutSuffix(host, "."+p.host)
+	return ok && sub != ""
+}
@@ -0,0 +1,67 @@
+package origin
+
+import "testing"
+
+func TestAllowlistAllowed(t *testing.T) {
+	allowlist, err := NewAllowlist([]string{
+		"https://example.com",
+		"http://localhost:3000",
+		"https://*.games.example.org",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		origin string
+		host   string
+		want   bool
+	}{
+		{name: "no origin", origin: "", host: "api.example.net", want: true},
+		{name: "same origin", origin: "https://api.example.net", host: "api.example.net", want: true},
+		{name: "same origin with port", origin: "http://localhost:8080", host: "localhost:8080", want: true},
+		{name: "same origin different case", origin: "https://API.example.net", host: "api.EXAMPLE.net", want: true},
+		{name: "exact match", origin: "https://example.com", host: "api.example.net", want: true},
+		{name: "exact match different case", origin: "HTTPS://Example.COM", host: "api.example.net", want: true},
+		{name: "exact match with port", origin: "http://localhost:3000", host: "api.example.net", want: true},
+		{name: "wrong port", origin: "http://localhost:3001", host: "api.example.net", want: false},
+		{name: "wrong scheme", origin: "http://example.com", host: "api.example.net", want: false},
+		{name: "subdomain of exact entry", origin: "https://www.example.com", host: "api.example.net", want: false},
+		{name: "lookalike suffix", origin: "https://evilexample.com", host: "api.example.net", want: false},
+		{name: "wildcard subdomain", origin: "https://play.games.example.org", host: "api.example.net", want: true},
+		{name: "wildcard nested subdomain", origin: "https://a.b.games.example.org", host: "api.example.net", want: true},
+		{name: "wildcard bare domain", origin: "https://games.example.org", host: "api.example.net", want: false},
+		{name: "wildcard lookalike", origin: "https://evilgames.example.org", host: "api.example.net", want: false},
+		{name: "wildcard wrong scheme", origin: "http://play.games.example.org", host: "api.example.net", want: false},
+		{name: "null origin", origin: "null", host: "api.example.net", want: false},
+		{name: "unparsable origin", origin: "https://exa mple.com:x", host: "api.example.net", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := allowlist.Allowed(tt.origin, tt.host); got != tt.want {
+				t.Errorf("Allowed(%q, %q) = %v, want %v", tt.origin, tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAllowlistInvalid(t *testing.T) {
+	tests := []string{
+		"example.com",
+		"https://",
+		"https://example.com/",
+		"https://*.",
+		"https://*.*.example.com",
+		"https://play.*.example.com",
+	}
+
+	for _, origin := range tests {
+		t.Run(origin, func(t *testing.T) {
+			if _, err := NewAllowlist([]string{origin}); err == nil {
+				t.Errorf("NewAllowlist(%q) succeeded, want an error", origin)
+			}
+		})
+	}
+}
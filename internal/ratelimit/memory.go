@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// bucket is the state of one client's token bucket
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryLimiter keeps token buckets in process memory, so limits only hold
+// within one instance
+type MemoryLimiter struct {
+	rate  float64
+	burst float64
+	// fill is how long an empty bucket takes to refill. Buckets idle for
+	// longer are full and are dropped by sweeps.
+	fill time.Duration
+	// now is the clock, replaced in tests
+	now       func() time.Time
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewMemoryLimiter creates a limiter whose buckets hold burst tokens and
+// refill at rate tokens per second
+func NewMemoryLimiter(rate, burst int) *MemoryLimiter {
+	return &MemoryLimiter{
+		rate:      float64(rate),
+		burst:     float64(burst),
+		fill:      time.Duration(float64(burst) / float64(rate) * float64(time.Second)),
+		now:       time.Now,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow implements Limiter
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, cost int) (bool, time.Duration, error) {
+	if float64(cost) > l.burst {
+		return false, 0, fmt.Errorf("%w: %d tokens, at most %d", ErrCostExceedsBurst, cost, int(l.burst))
+	}
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweepLocked(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+	b.updated = now
+	if b.tokens < float64(cost) {
+		wait := time.Duration((float64(cost) - b.tokens) / l.rate * float64(time.Second))
+		return false, wait, nil
+	}
+	b.tokens -= float64(cost)
+	return true, 0, nil
+}
+
+// Burst implements Limiter
+func (l *MemoryLimiter) Burst() int {
+	return int(l.burst)
+}
+
+// sweepLocked drops buckets that have refilled, at most once per fill period
+func (l *MemoryLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < l.fill {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= l.fill {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrCostExceedsBurst is returned for an action costing more tokens than a
+// bucket can hold, which could never be allowed
+var ErrCostExceedsBurst = errors.New("cost exceeds rate limit burst")
+
+// Limiter is a token bucket per client key. Each bucket holds up to burst
+// tokens and refills at rate tokens per second; every limited action takes
+// one token per cell it changes.
+type Limiter interface {
+	// Allow takes cost tokens from key's bucket. If the bucket holds fewer
+	// it takes none and reports false and how long until enough are
+	// available.
+	Allow(ctx context.Context, key string, cost int) (bool, time.Duration, error)
+	// Burst returns how many tokens a bucket holds, the most one action may
+	// cost
+	Burst() int
+}
+
+// contextKey is the context key of the request's client key
+type contextKey struct{}
+
+// NewContext returns a copy of ctx whose actions are limited under key
+func NewContext(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// KeyFrom returns the client key actions made with ctx are limited under,
+// or "" if there is none
+func KeyFrom(ctx context.Context) string {
+	key, _ := ctx.Value(contextKey{}).(string)
+	return key
+}
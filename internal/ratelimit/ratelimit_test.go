@@ -0,0 +1,200 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/usman-007/checkbox-backend/config"
+	"github.com/usman-007/checkbox-backend/internal/redis"
+)
+
+// testLimiter is a Limiter whose clock a test moves by hand
+type testLimiter struct {
+	Limiter
+	advance func(d time.Duration)
+}
+
+func newTestMemoryLimiter(t *testing.T, rate, burst int) testLimiter {
+	l := NewMemoryLimiter(rate, burst)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	l.lastSweep = now
+	return testLimiter{Limiter: l, advance: func(d time.Duration) { now = now.Add(d) }}
+}
+
+func newTestRedisLimiter(t *testing.T, rate, burst int) testLimiter {
+	server := miniredis.RunT(t)
+	client, err := redis.NewClient(&config.RedisConfig{Address: server.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	// The script reads Redis's clock, which miniredis lets us set
+	now := time.Now()
+	server.SetTime(now)
+	return testLimiter{Limiter: NewRedisLimiter(client, rate, burst), advance: func(d time.Duration) {
+		now = now.Add(d)
+		server.SetTime(now)
+	}}
+}
+
+var limiters = []struct {
+	name string
+	new  func(t *testing.T, rate, burst int) testLimiter
+}{
+	{name: "memory", new: newTestMemoryLimiter},
+	{name: "redis", new: newTestRedisLimiter},
+}
+
+// allowStep is one call to Allow, after moving the clock by advance
+type allowStep struct {
+	advance time.Duration
+	key     string
+	cost    int
+	allowed bool
+	wait    time.Duration
+	err     error
+}
+
+func TestLimiterAllow(t *testing.T) {
+	// Buckets hold 5 tokens and refill at 10 per second, one per 100ms
+	const rate, burst = 10, 5
+
+	tests := []struct {
+		name  string
+		steps []allowStep
+	}{
+		{
+			name: "burst then refuse",
+			steps: []allowStep{
+				{cost: 5, allowed: true},
+				{cost: 1, allowed: false, wait: 100 * time.Millisecond},
+			},
+		},
+		{
+			name: "one at a time",
+			steps: []allowStep{
+				{cost: 1, allowed: true},
+				{cost: 1, allowed: true},
+				{cost: 1, allowed: true},
+				{cost: 1, allowed: true},
+				{cost: 1, allowed: true},
+				{cost: 1, allowed: false, wait: 100 * time.Millisecond},
+			},
+		},
+		{
+			name: "refill over time",
+			steps: []allowStep{
+				{cost: 5, allowed: true},
+				{advance: 250 * time.Millisecond, cost: 2, allowed: true},
+				{cost: 1, allowed: false, wait: 50 * time.Millisecond},
+				{advance: 50 * time.Millisecond, cost: 1, allowed: true},
+			},
+		},
+		{
+			name: "refill stops at burst",
+			steps: []allowStep{
+				{cost: 5, allowed: true},
+				{advance: 10 * time.Second, cost: 5, allowed: true},
+				{cost: 1, allowed: false, wait: 100 * time.Millisecond},
+			},
+		},
+		{
+			name: "refused cost takes nothing",
+			steps: []allowStep{
+				{cost: 3, allowed: true},
+				{cost: 3, allowed: false, wait: 100 * time.Millisecond},
+				{cost: 2, allowed: true},
+			},
+		},
+		{
+			name: "cost above burst",
+			steps: []allowStep{
+				{cost: 6, err: ErrCostExceedsBurst},
+				{cost: 5, allowed: true},
+			},
+		},
+		{
+			name: "keys have separate buckets",
+			steps: []allowStep{
+				{key: "a", cost: 5, allowed: true},
+				{key: "a", cost: 1, allowed: false, wait: 100 * time.Millisecond},
+				{key: "b", cost: 5, allowed: true},
+			},
+		},
+	}
+
+	for _, limiter := range limiters {
+		for _, tt := range tests {
+			t.Run(limiter.name+"/"+tt.name, func(t *testing.T) {
+				l := limiter.new(t, rate, burst)
+				for i, step := range tt.steps {
+					l.advance(step.advance)
+					key := step.key
+					if key == "" {
+						key = "client"
+					}
+					allowed, wait, err := l.Allow(context.Background(), key, step.cost)
+					if !errors.Is(err, step.err) {
+						t.Fatalf("step %d: err = %v, want %v", i, err, step.err)
+					}
+					if allowed != step.allowed {
+						t.Errorf("step %d: allowed = %v, want %v", i, allowed, step.allowed)
+					}
+					// The Redis script works in whole milliseconds
+					if diff := wait - step.wait; diff < -time.Millisecond || diff > time.Millisecond {
+						t.Errorf("step %d: wait = %v, want %v", i, wait, step.wait)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestMemoryLimiterSweep(t *testing.T) {
+	l := NewMemoryLimiter(10, 5)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	l.lastSweep = now
+	ctx := context.Background()
+
+	l.Allow(ctx, "idle", 1)
+	now = now.Add(400 * time.Millisecond)
+	l.Allow(ctx, "busy", 1)
+	// Half a second refills a bucket of 5 at 10 per second
+	now = now.Add(100 * time.Millisecond)
+	l.Allow(ctx, "busy", 1)
+
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("bucket idle for a whole fill period was not swept")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("bucket in use was swept")
+	}
+}
+
+func TestRedisLimiterExpiry(t *testing.T) {
+	server := miniredis.RunT(t)
+	client, err := redis.NewClient(&config.RedisConfig{Address: server.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	l := NewRedisLimiter(client, 10, 5)
+	if _, _, err := l.Allow(context.Background(), "client", 1); err != nil {
+		t.Fatal(err)
+	}
+	// The bucket expires once it would be full again, after half a second
+	key := redis.RateLimitKey("client")
+	if ttl := server.TTL(key); ttl != 500*time.Millisecond {
+		t.Errorf("bucket TTL = %v, want 500ms", ttl)
+	}
+	server.FastForward(500 * time.Millisecond)
+	if server.Exists(key) {
+		t.Error("bucket still exists after its TTL")
+	}
+}
@@ -0,0 +1,40 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/usman-007/checkbox-backend/internal/redis"
+)
+
+// RedisLimiter keeps token buckets in Redis, so limits hold across every
+// instance sharing it
+type RedisLimiter struct {
+	client *redis.Client
+	rate   int
+	burst  int
+}
+
+// NewRedisLimiter creates a limiter whose buckets hold burst tokens and
+// refill at rate tokens per second
+func NewRedisLimiter(client *redis.Client, rate, burst int) *RedisLimiter {
+	return &RedisLimiter{
+		client: client,
+		rate:   rate,
+		burst:  burst,
+	}
+}
+
+// Allow implements Limiter
+func (l *RedisLimiter) Allow(ctx context.Context, key string, cost int) (bool, time.Duration, error) {
+	if cost > l.burst {
+		return false, 0, fmt.Errorf("%w: %d tokens, at most %d", ErrCostExceedsBurst, cost, l.burst)
+	}
+	return l.client.TakeRateLimitTokens(ctx, key, l.rate, l.burst, cost)
+}
+
+// Burst implements Limiter
+func (l *RedisLimiter) Burst() int {
+	return l.burst
+}
@@ -2,7 +2,6 @@ package redis
 
 import (
 	"context"
-	"fmt"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -33,34 +32,6 @@ func NewClient(cfg *config.RedisConfig) (*Client, error) {
 	return &Client{client}, nil
 }
 
-func (c *Client) InitializeGridState(ctx context.Context, rows, cols int) error {
-	// Create a pipeline
-	pipe := c.Client.Pipeline()
-
-	// Iterate through each cell of the grid
-	for r := range rows {
-		for col := range cols {
-			// Format the key according to your specified format
-			key := fmt.Sprintf("states:(%d,%d)", r, col)
-
-			// Queue the SET command in the pipeline.
-			// We set the value to 0 (representing false).
-			// We use 0 for expiration, meaning the key won't expire.
-			pipe.Set(ctx, key, 0, 0)
-		}
-	}
-
-	// Execute all commands in the pipeline
-	_, err := pipe.Exec(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to execute grid initialization pipeline: %w", err)
-	}
-
-	fmt.Printf("Successfully initialized %d x %d grid states to 0.\n", rows, cols)
-	return nil
-}
-
-
 // Set stores a key-value pair with expiration
 func (c *Client) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
 	return c.Client.Set(ctx, key, value, expiration).Err()
@@ -89,4 +60,4 @@ func (c *Client) HashGet(ctx context.Context, key, field string) (string, error)
 // HashGetAll gets all fields from a hash stored at key
 func (c *Client) HashGetAll(ctx context.Context, key string) (map[string]string, error) {
 	return c.Client.HGetAll(ctx, key).Result()
-} 
\ No newline at end of file
+}
@@ -0,0 +1,619 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// GridsKey is the Redis set holding the IDs of every grid
+const GridsKey = "grids"
+
+// GridChunkSize is the width and height of the square chunks a grid is
+// stored in
+const GridChunkSize = 64
+
+// GridChunkKey returns the Redis key holding one chunk of a grid as a bitmap
+// of GridChunkSize x GridChunkSize cells. Chunks that were never written do
+// not exist and read as all zeros.
+func GridChunkKey(id string, chunkRow, chunkCol int) string {
+	return fmt.Sprintf("grid:%s:chunk:%d:%d", id, chunkRow, chunkCol)
+}
+
+// GridChunkBit returns the chunk holding cell (row, col) and the cell's bit
+// offset within that chunk's bitmap
+func GridChunkBit(row, col int) (chunkRow, chunkCol, bit int) {
+	return row / GridChunkSize, col / GridChunkSize, (row%GridChunkSize)*GridChunkSize + col%GridChunkSize
+}
+
+// GridCellMetadataKey returns the Redis hash holding the metadata of the
+// cells of one chunk, keyed by their bit offset within the chunk. Only grids
+// that keep cell metadata write it.
+func GridCellMetadataKey(id string, chunkRow, chunkCol int) string {
+	return fmt.Sprintf("grid:%s:cellmeta:%d:%d", id, chunkRow, chunkCol)
+}
+
+// gridChunkKeys returns the keys of every chunk of a rows x cols grid,
+// together with the keys of their cell metadata
+func gridChunkKeys(id string, rows, cols int) []string {
+	chunkRows := (rows + GridChunkSize - 1) / GridChunkSize
+	chunkCols := (cols + GridChunkSize - 1) / GridChunkSize
+	keys := make([]string, 0, 2*chunkRows*chunkCols)
+	for r := 0; r < chunkRows; r++ {
+		for c := 0; c < chunkCols; c++ {
+			keys = append(keys, GridChunkKey(id, r, c), GridCellMetadataKey(id, r, c))
+		}
+	}
+	return keys
+}
+
+// gridFlatStateKey returns the key that held a grid as one bitmap, cell
+// (row, col) at bit row*cols+col, before grids were split into chunks
+func gridFlatStateKey(id string) string {
+	return "grid:" + id + ":state"
+}
+
+// GridMetaKey returns the Redis hash holding a grid's "rows", "cols" and
+// "version", the number of changes made to it so far
+func GridMetaKey(id string) string {
+	return "grid:" + id + ":meta"
+}
+
+// GridChangesKey returns the Redis stream logging a grid's recent changes.
+// Entry IDs are "0-<version>", so a range of versions maps to a range of IDs.
+func GridChangesKey(id string) string {
+	return "grid:" + id + ":changes"
+}
+
+// GridDeletionsChannel is the Pub/Sub channel the ID of every deleted grid is
+// published on, so instances caching the grid can forget it
+const GridDeletionsChannel = "grids:deleted"
+
+// GridUpdatesChannel returns the Pub/Sub channel a grid's changes are published on
+func GridUpdatesChannel(id string) string {
+	return "grid:" + id + ":updates"
+}
+
+// legacyCellPattern matches the old one-key-per-cell layout, e.g. "states:(3,14)"
+var legacyCellPattern = regexp.MustCompile(`^states:\((\d+),(\d+)\)$`)
+
+// Keys used by the single-grid bitmap layout, before grids had IDs
+const (
+	legacyStateKey = "grid:state"
+	legacyMetaKey  = "grid:meta"
+)
+
+// ErrGridDimensionMismatch is returned when the grid stored in Redis was
+// created with different dimensions than the ones requested
+var ErrGridDimensionMismatch = errors.New("stored grid dimensions do not match")
+
+// ErrGridNotFound is returned when writing to a grid that does not exist,
+// for example because another instance deleted it
+var ErrGridNotFound = errors.New("grid not found")
+
+// noGridReply prefixes the error the cell scripts return for a grid whose
+// meta key is gone, so they do not recreate the keys of a deleted grid
+const noGridReply = "NOGRID"
+
+// gridScriptError translates a cell script's missing-grid error into
+// ErrGridNotFound
+func gridScriptError(err error, id string) error {
+	if err != nil && strings.HasPrefix(err.Error(), noGridReply) {
+		return fmt.Errorf("%w: %q", ErrGridNotFound, id)
+	}
+	return err
+}
+
+// InitializeGridState records the dimensions of a rows x cols grid unless the
+// grid already exists. Its chunks are created as cells are set, so every
+// checkbox starts unchecked. Existing state is left untouched, so it is safe
+// to call on every startup. If the stored grid has different dimensions
+// ErrGridDimensionMismatch is returned and ReplaceGridState must be used to
+// replace it.
+func (c *Client) InitializeGridState(ctx context.Context, id string, rows, cols int) error {
+	// The NX variants only write what is missing, so concurrent instances
+	// starting together agree on a single grid.
+	pipe := c.Client.TxPipeline()
+	created := pipe.HSetNX(ctx, GridMetaKey(id), "rows", rows)
+	pipe.HSetNX(ctx, GridMetaKey(id), "cols", cols)
+	pipe.SAdd(ctx, GridsKey, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to initialize grid state: %w", err)
+	}
+
+	storedRows, storedCols, _, err := c.GetGridDimensions(ctx, id)
+	if err != nil {
+		return err
+	}
+	if storedRows != rows || storedCols != cols {
+		return fmt.Errorf("%w: stored grid %q is %dx%d, requested grid is %dx%d",
+			ErrGridDimensionMismatch, id, storedRows, storedCols, rows, cols)
+	}
+
+	if created.Val() {
+		fmt.Printf("Successfully initialized %d x %d grid %q states to 0.\n", rows, cols, id)
+	}
+	return nil
+}
+
+// resetGridScript zeroes a grid and records its dimensions. A reset is a
+// change too, so clients holding older state can tell: it bumps the version
+// and, since logged changes no longer lead to the current state, drops them.
+// Unless ARGV[3] is "1" it returns a NOGRID error without writing anything if
+// the grid does not exist, so a reset cannot recreate a deleted grid.
+//
+// KEYS[1] meta key, KEYS[2] changes stream, KEYS[3] set of grid IDs, then
+// the chunk and cell metadata keys to clear
+// ARGV[1] rows, ARGV[2] cols, ARGV[3] whether to create a missing grid,
+// ARGV[4] grid ID
+var resetGridScript = redis.NewScript(`
+if ARGV[3] ~= '1' and redis.call('HEXISTS', KEYS[1], 'rows') == 0 then
+  return redis.error_reply('NOGRID grid does not exist')
+end
+for i = 4, #KEYS do
+  redis.call('DEL', KEYS[i])
+end
+redis.call('HSET', KEYS[1], 'rows', ARGV[1], 'cols', ARGV[2])
+local version = redis.call('HINCRBY', KEYS[1], 'version', 1)
+redis.call('DEL', KEYS[2])
+redis.call('SADD', KEYS[3], ARGV[4])
+return version
+`)
+
+// ResetGridState zeroes an existing grid, giving it rows x cols dimensions,
+// and returns the grid's new version. It returns ErrGridNotFound if the grid
+// does not exist, for example because another instance deleted it.
+func (c *Client) ResetGridState(ctx context.Context, id string, rows, cols int) (int64, error) {
+	return c.resetGridState(ctx, id, rows, cols, false)
+}
+
+// ReplaceGridState replaces any stored grid with a zeroed rows x cols grid,
+// creating it if needed, and returns the grid's new version
+func (c *Client) ReplaceGridState(ctx context.Context, id string, rows, cols int) (int64, error) {
+	return c.resetGridState(ctx, id, rows, cols, true)
+}
+
+// resetGridState runs resetGridScript for a grid
+func (c *Client) resetGridState(ctx context.Context, id string, rows, cols int, create bool) (int64, error) {
+	// Clear the chunks of the stored grid too, which may be larger
+	storedRows, storedCols, _, err := c.GetGridDimensions(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	keys := append([]string{GridMetaKey(id), GridChangesKey(id), GridsKey}, gridChunkKeys(id, max(rows, storedRows), max(cols, storedCols))...)
+	version, err := resetGridScript.Run(ctx, c.Client, keys, rows, cols, bitOf(create), id).Int64()
+	if err != nil {
+		if err = gridScriptError(err, id); errors.Is(err, ErrGridNotFound) {
+			return 0, err
+		}
+		return 0, fmt.Errorf("failed to reset grid state: %w", err)
+	}
+
+	fmt.Printf("Reset %d x %d grid %q states to 0.\n", rows, cols, id)
+	return version, nil
+}
+
+// GetGridDimensions returns the dimensions stored alongside a grid. ok is
+// false when the grid does not exist.
+func (c *Client) GetGridDimensions(ctx context.Context, id string) (rows, cols int, ok bool, err error) {
+	meta, err := c.Client.HGetAll(ctx, GridMetaKey(id)).Result()
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("failed to get grid dimensions: %w", err)
+	}
+	if meta["rows"] == "" || meta["cols"] == "" {
+		return 0, 0, false, nil
+	}
+	rows, err = strconv.Atoi(meta["rows"])
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("invalid stored grid rows %q: %w", meta["rows"], err)
+	}
+	cols, err = strconv.Atoi(meta["cols"])
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("invalid stored grid cols %q: %w", meta["cols"], err)
+	}
+	return rows, cols, true, nil
+}
+
+// ListGrids returns the IDs of every stored grid
+func (c *Client) ListGrids(ctx context.Context) ([]string, error) {
+	ids, err := c.Client.SMembers(ctx, GridsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list grids: %w", err)
+	}
+	return ids, nil
+}
+
+// DeleteGrid removes a grid's state and metadata. It reports whether the grid existed.
+func (c *Client) DeleteGrid(ctx context.Context, id string) (bool, error) {
+	rows, cols, _, err := c.GetGridDimensions(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	pipe := c.Client.TxPipeline()
+	removed := pipe.SRem(ctx, GridsKey, id)
+	pipe.Del(ctx, append(gridChunkKeys(id, rows, cols), GridMetaKey(id), GridChangesKey(id))...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, fmt.Errorf("failed to delete grid %q: %w", id, err)
+	}
+	return removed.Val() == 1, nil
+}
+
+// MigrateLegacyGridState moves state left over from older layouts into the
+// grid with the given ID: the single "grid:state"/"grid:meta" bitmap is renamed, and
+// per-cell "states:(r,c)" keys are folded into the bitmap and deleted. Cells
+// outside the rows x cols grid are dropped. The result is a grid stored as one
+// bitmap, which MigrateFlatGridStates then splits into chunks. It must run
+// before the default grid is initialized, returns the number of keys migrated
+// and is a no-op once no legacy keys remain.
+func (c *Client) MigrateLegacyGridState(ctx context.Context, id string, rows, cols int) (int, error) {
+	migrated := 0
+
+	// Single-bitmap layout: rename the keys unless the grid already exists
+	for legacy, current := range map[string]string{
+		legacyStateKey: gridFlatStateKey(id),
+		legacyMetaKey:  GridMetaKey(id),
+	} {
+		renamed, err := c.Client.RenameNX(ctx, legacy, current).Result()
+		if err != nil && !isNoSuchKey(err) {
+			return migrated, fmt.Errorf("failed to migrate %s: %w", legacy, err)
+		}
+		if renamed {
+			migrated++
+		}
+	}
+
+	// Per-cell layout
+	stateKey := gridFlatStateKey(id)
+	var cursor uint64
+	for {
+		keys, next, err := c.Client.Scan(ctx, cursor, "states:*", 500).Result()
+		if err != nil {
+			return migrated, fmt.Errorf("failed to scan legacy grid keys: %w", err)
+		}
+
+		if len(keys) > 0 {
+			// Read every legacy bit in one round-trip
+			readPipe := c.Client.Pipeline()
+			bits := make([]*redis.IntCmd, len(keys))
+			for i, key := range keys {
+				bits[i] = readPipe.GetBit(ctx, key, 0)
+			}
+			if _, err := readPipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+				return migrated, fmt.Errorf("failed to read legacy grid keys: %w", err)
+			}
+
+			// Copy the bits into the bitmap and drop the old keys atomically
+			writePipe := c.Client.TxPipeline()
+			for i, key := range keys {
+				match := legacyCellPattern.FindStringSubmatch(key)
+				if match == nil {
+					continue
+				}
+				row, _ := strconv.Atoi(match[1])
+				col, _ := strconv.Atoi(match[2])
+				if row < rows && col < cols && bits[i].Val() == 1 {
+					writePipe.SetBit(ctx, stateKey, int64(row*cols+col), 1)
+				}
+				writePipe.Del(ctx, key)
+				migrated++
+			}
+			if _, err := writePipe.Exec(ctx); err != nil {
+				return migrated, fmt.Errorf("failed to migrate legacy grid keys: %w", err)
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	if migrated > 0 {
+		// Register the grid so MigrateFlatGridStates finds it, keeping the
+		// dimensions of a renamed grid
+		pipe := c.Client.TxPipeline()
+		pipe.HSetNX(ctx, GridMetaKey(id), "rows", rows)
+		pipe.HSetNX(ctx, GridMetaKey(id), "cols", cols)
+		pipe.SAdd(ctx, GridsKey, id)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return migrated, fmt.Errorf("failed to register migrated grid %q: %w", id, err)
+		}
+		fmt.Printf("Migrated %d legacy grid keys into grid %q.\n", migrated, id)
+	}
+	return migrated, nil
+}
+
+// MigrateFlatGridStates splits every grid still stored as a single bitmap
+// into chunks. It returns the number of grids migrated and is a no-op once
+// no flat bitmaps remain.
+func (c *Client) MigrateFlatGridStates(ctx context.Context) (int, error) {
+	ids, err := c.ListGrids(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, id := range ids {
+		ok, err := c.migrateFlatGridState(ctx, id)
+		if err != nil {
+			return migrated, err
+		}
+		if ok {
+			migrated++
+		}
+	}
+	if migrated > 0 {
+		fmt.Printf("Split %d grids into %dx%d chunks.\n", migrated, GridChunkSize, GridChunkSize)
+	}
+	return migrated, nil
+}
+
+// migrateFlatGridState splits one grid's flat bitmap into chunks, reporting
+// whether there was one. Set bits are OR-ed into the chunks so cells written
+// since are kept.
+func (c *Client) migrateFlatGridState(ctx context.Context, id string) (bool, error) {
+	flatKey := gridFlatStateKey(id)
+	bitmap, err := c.Client.Get(ctx, flatKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read flat state of grid %q: %w", id, err)
+	}
+	rows, cols, ok, err := c.GetGridDimensions(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		// Without dimensions the bits cannot be placed; leave them be
+		return false, nil
+	}
+
+	pipe := c.Client.TxPipeline()
+	for i := 0; i < len(bitmap)*8 && i < rows*cols; i++ {
+		if bitmap[i/8]&(0x80>>(i%8)) == 0 {
+			continue
+		}
+		chunkRow, chunkCol, bit := GridChunkBit(i/cols, i%cols)
+		pipe.SetBit(ctx, GridChunkKey(id, chunkRow, chunkCol), int64(bit), 1)
+	}
+	pipe.Del(ctx, flatKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, fmt.Errorf("failed to split grid %q into chunks: %w", id, err)
+	}
+	return true, nil
+}
+
+// isNoSuchKey reports whether err is Redis complaining that a renamed key does not exist
+func isNoSuchKey(err error) bool {
+	return err != nil && err.Error() == "ERR no such key"
+}
+
+// GridChunk identifies a chunk of a grid by its position in the chunk grid
+type GridChunk struct {
+	Row int
+	Col int
+}
+
+// GetGridChunks returns the bitmaps of the given chunks of a grid and the
+// grid's version, read atomically. Chunks that were never written are
+// returned as nil.
+func (c *Client) GetGridChunks(ctx context.Context, id string, chunks []GridChunk) ([][]byte, int64, error) {
+	keys := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		keys[i] = GridChunkKey(id, chunk.Row, chunk.Col)
+	}
+
+	pipe := c.Client.TxPipeline()
+	values := pipe.MGet(ctx, keys...)
+	version := pipe.HGet(ctx, GridMetaKey(id), "version")
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, 0, err
+	}
+
+	bitmaps := make([][]byte, len(chunks))
+	for i, value := range values.Val() {
+		if s, ok := value.(string); ok {
+			bitmaps[i] = []byte(s)
+		}
+	}
+
+	v, err := version.Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, 0, fmt.Errorf("invalid stored grid version: %w", err)
+	}
+	return bitmaps, v, nil
+}
+
+// Ways updateCellScript can pick a cell's new value
+const (
+	cellSet           = "set"
+	cellToggle        = "toggle"
+	cellCompareAndSet = "cas"
+)
+
+// updateCellScript sets a bit in a grid chunk, bumps the grid version, logs
+// the change and publishes it in one atomic step, so published and logged
+// versions are ordered and gap-free. The new bit is ARGV[2] for "set", the
+// opposite of the current bit for "toggle", and ARGV[2] for "cas" only if
+// the current bit equals ARGV[8]. It returns {applied, bit, version}: when
+// a "cas" does not apply, nothing is written and bit and version are the
+// current ones. Non-empty cell metadata in ARGV[9], a JSON object, is
+// stored for the cell and logged and published with the change. If the grid
+// has been deleted nothing is written and a NOGRID error is returned.
+//
+// KEYS[1] chunk key, KEYS[2] meta key, KEYS[3] changes stream, KEYS[4] cell metadata key
+// ARGV[1] bit offset within the chunk, ARGV[2] bit, ARGV[3] updates channel, ARGV[4] row, ARGV[5] col,
+// ARGV[6] approximate number of changes to keep in the stream, ARGV[7] mode, ARGV[8] expected bit,
+// ARGV[9] cell metadata
+var updateCellScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[2], 'rows') == 0 then
+  return redis.error_reply('NOGRID grid does not exist')
+end
+local bit = tonumber(ARGV[2])
+if ARGV[7] ~= 'set' then
+  local current = redis.call('GETBIT', KEYS[1], ARGV[1])
+  if ARGV[7] == 'toggle' then
+    bit = 1 - current
+  elseif current ~= tonumber(ARGV[8]) then
+    return {0, current, tonumber(redis.call('HGET', KEYS[2], 'version') or 0)}
+  end
+end
+redis.call('SETBIT', KEYS[1], ARGV[1], bit)
+local version = redis.call('HINCRBY', KEYS[2], 'version', 1)
+redis.call('XADD', KEYS[3], 'MAXLEN', '~', ARGV[6], '0-' .. version, 'row', ARGV[4], 'col', ARGV[5], 'value', bit, 'meta', ARGV[9])
+local value = 'false'
+if bit == 1 then value = 'true' end
+local meta = ''
+if ARGV[9] ~= '' then
+  redis.call('HSET', KEYS[4], ARGV[1], ARGV[9])
+  meta = ',"meta":' .. ARGV[9]
+end
+redis.call('PUBLISH', ARGV[3], string.format('{"row":%s,"column":%s,"value":%s,"version":%d%s}', ARGV[4], ARGV[5], value, version, meta))
+return {1, bit, version}
+`)
+
+// updateGridCell runs updateCellScript for the cell at (row, col) of a grid.
+// It reports whether the update applied, the cell's resulting value and the
+// grid's resulting version, or ErrGridNotFound if the grid is gone.
+func (c *Client) updateGridCell(ctx context.Context, id string, row, col int, mode string, value, expected bool, meta string, logSize int) (bool, bool, int64, error) {
+	chunkRow, chunkCol, offset := GridChunkBit(row, col)
+	keys := []string{GridChunkKey(id, chunkRow, chunkCol), GridMetaKey(id), GridChangesKey(id), GridCellMetadataKey(id, chunkRow, chunkCol)}
+	result, err := updateCellScript.Run(ctx, c.Client, keys, offset, bitOf(value), GridUpdatesChannel(id), row, col, logSize, mode, bitOf(expected), meta).Int64Slice()
+	if err != nil {
+		return false, false, 0, gridScriptError(err, id)
+	}
+	if len(result) != 3 {
+		return false, false, 0, fmt.Errorf("unexpected cell update result %v", result)
+	}
+	return result[0] == 1, result[1] == 1, result[2], nil
+}
+
+// SetGridCell sets the cell at (row, col) of a grid, logs the change in the
+// grid's changes stream (keeping roughly logSize entries), publishes it on
+// the grid's updates channel and returns the grid's new version. meta, the
+// cell's metadata as a JSON object, is stored and sent along unless empty.
+func (c *Client) SetGridCell(ctx context.Context, id string, row, col int, value bool, meta string, logSize int) (int64, error) {
+	_, _, version, err := c.updateGridCell(ctx, id, row, col, cellSet, value, false, meta, logSize)
+	return version, err
+}
+
+// ToggleGridCell flips the cell at (row, col) of a grid like SetGridCell,
+// returning the cell's new value and the grid's new version
+func (c *Client) ToggleGridCell(ctx context.Context, id string, row, col int, meta string, logSize int) (bool, int64, error) {
+	_, value, version, err := c.updateGridCell(ctx, id, row, col, cellToggle, false, false, meta, logSize)
+	return value, version, err
+}
+
+// CompareAndSetGridCell sets the cell at (row, col) of a grid like
+// SetGridCell, but only if it currently holds expected. It reports whether it
+// did, with the grid's new version; otherwise it returns the cell's current
+// value and the grid's current version.
+func (c *Client) CompareAndSetGridCell(ctx context.Context, id string, row, col int, expected, value bool, meta string, logSize int) (bool, bool, int64, error) {
+	return c.updateGridCell(ctx, id, row, col, cellCompareAndSet, value, expected, meta, logSize)
+}
+
+// bitOf converts a cell value to the bit stored for it
+func bitOf(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// setCellsScript applies several cell updates like updateCellScript's "set", one
+// version and log entry each, and publishes them all in one message: a JSON
+// array of changes. Every update gets the cell metadata in ARGV[3] unless it
+// is empty. It returns the version of the last update, or a NOGRID error
+// without writing anything if the grid has been deleted.
+//
+// KEYS[1] meta key, KEYS[2] changes stream, then per update its chunk key and
+// cell metadata key
+// ARGV[1] updates channel, ARGV[2] approximate number of changes to keep in
+// the stream, ARGV[3] cell metadata, then four arguments per update: bit
+// offset within the chunk, bit, row, col
+var setCellsScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], 'rows') == 0 then
+  return redis.error_reply('NOGRID grid does not exist')
+end
+local parts = {}
+local version = 0
+local meta = ''
+if ARGV[3] ~= '' then meta = ',"meta":' .. ARGV[3] end
+for i = 3, #KEYS, 2 do
+  local base = 3 + (i - 3) * 2
+  local offset, bit, row, col = ARGV[base + 1], ARGV[base + 2], ARGV[base + 3], ARGV[base + 4]
+  redis.call('SETBIT', KEYS[i], offset, bit)
+  if ARGV[3] ~= '' then redis.call('HSET', KEYS[i + 1], offset, ARGV[3]) end
+  version = redis.call('HINCRBY', KEYS[1], 'version', 1)
+  redis.call('XADD', KEYS[2], 'MAXLEN', '~', ARGV[2], '0-' .. version, 'row', row, 'col', col, 'value', bit, 'meta', ARGV[3])
+  local value = 'false'
+  if bit == '1' then value = 'true' end
+  parts[#parts + 1] = string.format('{"row":%s,"column":%s,"value":%s,"version":%d%s}', row, col, value, version, meta)
+end
+redis.call('PUBLISH', ARGV[1], '[' .. table.concat(parts, ',') .. ']')
+return version
+`)
+
+// GridCell is a cell value to store
+type GridCell struct {
+	Row   int
+	Col   int
+	Value bool
+}
+
+// SetGridCells sets several cells of a grid in one atomic step. Each update
+// gets its own version and change log entry, in order, and all of them are
+// published on the grid's updates channel as one message. meta is stored and
+// sent along with every update like SetGridCell's. It returns the version of
+// the last update.
+func (c *Client) SetGridCells(ctx context.Context, id string, cells []GridCell, meta string, logSize int) (int64, error) {
+	keys := make([]string, 0, 2+2*len(cells))
+	keys = append(keys, GridMetaKey(id), GridChangesKey(id))
+	args := make([]interface{}, 0, 3+4*len(cells))
+	args = append(args, GridUpdatesChannel(id), logSize, meta)
+	for _, cell := range cells {
+		chunkRow, chunkCol, offset := GridChunkBit(cell.Row, cell.Col)
+		keys = append(keys, GridChunkKey(id, chunkRow, chunkCol), GridCellMetadataKey(id, chunkRow, chunkCol))
+		args = append(args, offset, bitOf(cell.Value), cell.Row, cell.Col)
+	}
+	version, err := setCellsScript.Run(ctx, c.Client, keys, args...).Int64()
+	return version, gridScriptError(err, id)
+}
+
+// GetGridCell returns the state of the cell at (row, col) of a grid and its
+// metadata as a JSON object, which is empty if none was stored
+func (c *Client) GetGridCell(ctx context.Context, id string, row, col int) (bool, string, error) {
+	chunkRow, chunkCol, offset := GridChunkBit(row, col)
+	pipe := c.Client.TxPipeline()
+	bit := pipe.GetBit(ctx, GridChunkKey(id, chunkRow, chunkCol), int64(offset))
+	meta := pipe.HGet(ctx, GridCellMetadataKey(id, chunkRow, chunkCol), strconv.Itoa(offset))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return false, "", err
+	}
+	return bit.Val() == 1, meta.Val(), nil
+}
+
+// GetGridChanges returns the logged changes of a grid with a version above
+// since, oldest first, together with the grid's current version
+func (c *Client) GetGridChanges(ctx context.Context, id string, since int64) ([]redis.XMessage, int64, error) {
+	pipe := c.Client.TxPipeline()
+	entries := pipe.XRange(ctx, GridChangesKey(id), fmt.Sprintf("0-%d", since+1), "+")
+	version := pipe.HGet(ctx, GridMetaKey(id), "version")
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, 0, fmt.Errorf("failed to read grid changes: %w", err)
+	}
+
+	v, err := version.Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, 0, fmt.Errorf("invalid stored grid version: %w", err)
+	}
+	return entries.Val(), v, nil
+}
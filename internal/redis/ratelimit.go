@@ -0,0 +1,60 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitKey returns the Redis hash holding the token bucket of a client
+// key, with its "tokens" and the time in milliseconds they were counted at
+// ("ts"). It expires once the bucket would be full again.
+func RateLimitKey(key string) string {
+	return "ratelimit:" + key
+}
+
+// takeTokensScript refills a token bucket for the time since it was last used
+// and takes tokens from it if it holds enough. The clock is Redis's own, so
+// every instance agrees on it. It returns whether the tokens were taken and,
+// if not, the milliseconds until enough are available.
+//
+// KEYS[1] bucket key
+// ARGV[1] tokens added per second, ARGV[2] bucket size, ARGV[3] tokens to take
+var takeTokensScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local clock = redis.call('TIME')
+local now = tonumber(clock[1]) * 1000 + math.floor(tonumber(clock[2]) / 1000)
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1]) or burst
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local allowed, wait = 0, 0
+if tokens >= cost then
+  tokens = tokens - cost
+  allowed = 1
+else
+  wait = math.ceil((cost - tokens) * 1000 / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * 1000 / rate))
+return {allowed, wait}
+`)
+
+// TakeRateLimitTokens takes cost tokens from the bucket of a client key,
+// which holds up to burst tokens and refills at rate tokens per second. If
+// the bucket holds fewer it takes none and reports false and how long until
+// enough are available.
+func (c *Client) TakeRateLimitTokens(ctx context.Context, key string, rate, burst, cost int) (bool, time.Duration, error) {
+	result, err := takeTokensScript.Run(ctx, c.Client, []string{RateLimitKey(key)}, rate, burst, cost).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(result) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit result %v", result)
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
@@ -2,75 +2,212 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
-	"github.com/redis/go-redis/v9"
+	"github.com/usman-007/checkbox-backend/internal/auth"
+	"github.com/usman-007/checkbox-backend/internal/models"
+	"github.com/usman-007/checkbox-backend/internal/session"
+	"github.com/usman-007/checkbox-backend/internal/store"
 )
 
-// CheckboxService handles operations related to checkboxes
+var (
+	// ErrCellOutOfRange is returned when a row or column falls outside the grid
+	ErrCellOutOfRange = errors.New("cell is outside the grid")
+
+	// ErrInvalidRegion is returned for an empty region or one that does not fit the grid
+	ErrInvalidRegion = errors.New("invalid region")
+
+	// ErrValueMismatch is returned when a checkbox does not hold the value a
+	// conditional update expected
+	ErrValueMismatch = errors.New("checkbox does not hold the expected value")
+
+	// ErrInvalidBatch is returned for a batch of updates that is empty or too large
+	ErrInvalidBatch = errors.New("invalid batch")
+)
+
+// MaxBatchSize is the most checkbox updates one batch may hold
+const MaxBatchSize = 1000
+
+// CheckboxService handles operations related to the checkboxes of one grid
 type CheckboxService struct {
-	RedisClient *redis.Client
+	ID    string
+	Store store.GridStore
+	Rows  int
+	Cols  int
+	// gone, when set, is called when a write finds the grid deleted, which
+	// happens when another instance deleted it
+	gone func()
 }
 
-// NewCheckboxService creates a new instance of CheckboxService
-func NewCheckboxService(redisClient *redis.Client) *CheckboxService {
+// NewCheckboxService creates a new instance of CheckboxService for the grid kept in gridStore
+func NewCheckboxService(id string, gridStore store.GridStore) *CheckboxService {
+	rows, cols := gridStore.Dimensions()
 	return &CheckboxService{
-		RedisClient: redisClient,
+		ID:    id,
+		Store: gridStore,
+		Rows:  rows,
+		Cols:  cols,
+	}
+}
+
+// GetGrid returns the grid's metadata
+func (s *CheckboxService) GetGrid() models.Grid {
+	return models.Grid{ID: s.ID, Rows: s.Rows, Cols: s.Cols}
+}
+
+// InBounds reports whether (row, column) is a cell of the grid
+func (s *CheckboxService) InBounds(row, column int) bool {
+	return row >= 0 && row < s.Rows && column >= 0 && column < s.Cols
+}
+
+// ValidateRegion checks that region is non-empty and lies within the grid
+func (s *CheckboxService) ValidateRegion(region models.Region) error {
+	if region.RowStart < 0 || region.ColStart < 0 || region.Rows() <= 0 || region.Cols() <= 0 ||
+		region.RowEnd > s.Rows || region.ColEnd > s.Cols {
+		return fmt.Errorf("%w: rows [%d,%d) and columns [%d,%d) must be non-empty and within %dx%d",
+			ErrInvalidRegion, region.RowStart, region.RowEnd, region.ColStart, region.ColEnd, s.Rows, s.Cols)
+	}
+	return nil
+}
+
+// Snapshot returns the whole grid as a bitmap along with its version
+func (s *CheckboxService) Snapshot(ctx context.Context) (store.Snapshot, error) {
+	return s.Store.Snapshot(ctx, s.GetGrid().Region())
+}
+
+// RegionSnapshot returns the cells of region as a bitmap of the region's own
+// width, along with the grid version. Only the storage holding the region is read.
+func (s *CheckboxService) RegionSnapshot(ctx context.Context, region models.Region) (store.Snapshot, error) {
+	if err := s.ValidateRegion(region); err != nil {
+		return store.Snapshot{}, err
+	}
+	return s.Store.Snapshot(ctx, region)
+}
+
+// attribute returns ctx with the changes made with it attributed to its
+// authenticated identity or, failing that, its session
+func attribute(ctx context.Context) context.Context {
+	if identity, ok := auth.FromContext(ctx); ok && !identity.Anonymous() {
+		return store.WithActor(ctx, identity.Subject)
+	}
+	if s, ok := session.FromContext(ctx); ok {
+		return store.WithActor(ctx, s.Actor())
 	}
+	return ctx
 }
 
-// GetAllCheckboxes retrieves all checkboxes with their states from Redis
-// Returns a map where keys are checkbox coordinates and values are their states (true/false)
-func (s *CheckboxService) GetAllCheckboxes() (map[string]bool, error) {
-	ctx := context.Background()
-	keys, err := s.RedisClient.Keys(ctx, "states:*").Result()
+// CheckboxMap expands the bitmap of a region into a map keyed by
+// "states:(r,c)", the shape clients have always received
+func CheckboxMap(region models.Region, bitmap []byte) map[string]bool {
+	result := make(map[string]bool, region.Rows()*region.Cols())
+	for r := region.RowStart; r < region.RowEnd; r++ {
+		for c := region.ColStart; c < region.ColEnd; c++ {
+			key := fmt.Sprintf("states:(%d,%d)", r, c)
+			result[key] = store.Bit(bitmap, (r-region.RowStart)*region.Cols()+c-region.ColStart)
+		}
+	}
+	return result
+}
+
+// GetCheckbox returns a checkbox's value and, if the grid keeps cell
+// metadata, when and by whom it was last changed
+func (s *CheckboxService) GetCheckbox(ctx context.Context, row, column int) (models.Checkbox, error) {
+	if !s.InBounds(row, column) {
+		return models.Checkbox{}, fmt.Errorf("%w: (%d,%d) is not within %dx%d", ErrCellOutOfRange, row, column, s.Rows, s.Cols)
+	}
+	value, meta, err := s.Store.GetCell(ctx, row, column)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get keys from Redis: %w", err)
-	}
-	
-	result := make(map[string]bool)
-	
-	// For each key, get its value
-	for _, key := range keys {
-		// Get the bit value (0 or 1)
-		bitValue, err := s.RedisClient.GetBit(ctx, key, 0).Result()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get value for key %s: %w", key, err)
+		return models.Checkbox{}, err
+	}
+	checkbox := models.Checkbox{Row: &row, Column: &column, Value: &value}
+	if meta != nil {
+		checkbox.UpdatedAt = &meta.UpdatedAt
+		checkbox.Actor = meta.Actor
+	}
+	return checkbox, nil
+}
+
+// UpdateCheckboxState updates the state of a checkbox, notifies subscribers
+// and returns the resulting change, including the grid version it produced
+func (s *CheckboxService) UpdateCheckboxState(ctx context.Context, row, column int, value bool) (store.Change, error) {
+	if !s.InBounds(row, column) {
+		return store.Change{}, fmt.Errorf("%w: (%d,%d) is not within %dx%d", ErrCellOutOfRange, row, column, s.Rows, s.Cols)
+	}
+	change, err := s.Store.SetCell(attribute(ctx), row, column, value)
+	s.checkGone(err)
+	return change, err
+}
+
+// ToggleCheckbox flips a checkbox, notifies subscribers and returns the
+// resulting change, which holds the checkbox's new value
+func (s *CheckboxService) ToggleCheckbox(ctx context.Context, row, column int) (store.Change, error) {
+	if !s.InBounds(row, column) {
+		return store.Change{}, fmt.Errorf("%w: (%d,%d) is not within %dx%d", ErrCellOutOfRange, row, column, s.Rows, s.Cols)
+	}
+	change, err := s.Store.ToggleCell(attribute(ctx), row, column)
+	s.checkGone(err)
+	return change, err
+}
+
+// CompareAndSetCheckbox sets a checkbox to value only if it currently holds
+// expected. On a mismatch it returns ErrValueMismatch together with a change
+// holding the checkbox's current value and the grid's current version.
+func (s *CheckboxService) CompareAndSetCheckbox(ctx context.Context, row, column int, expected, value bool) (store.Change, error) {
+	if !s.InBounds(row, column) {
+		return store.Change{}, fmt.Errorf("%w: (%d,%d) is not within %dx%d", ErrCellOutOfRange, row, column, s.Rows, s.Cols)
+	}
+	change, ok, err := s.Store.CompareAndSetCell(attribute(ctx), row, column, expected, value)
+	s.checkGone(err)
+	if err != nil {
+		return store.Change{}, err
+	}
+	if !ok {
+		return change, fmt.Errorf("%w: (%d,%d) is %t, expected %t", ErrValueMismatch, row, column, change.Value, expected)
+	}
+	return change, nil
+}
+
+// UpdateCheckboxStates applies several checkbox updates in one atomic step,
+// notifies subscribers of them together and returns the resulting changes in
+// order. Nothing is applied unless every cell is within the grid.
+func (s *CheckboxService) UpdateCheckboxStates(ctx context.Context, updates []store.CellUpdate) ([]store.Change, error) {
+	if len(updates) == 0 || len(updates) > MaxBatchSize {
+		return nil, fmt.Errorf("%w: must hold between 1 and %d updates, got %d", ErrInvalidBatch, MaxBatchSize, len(updates))
+	}
+	for _, update := range updates {
+		if !s.InBounds(update.Row, update.Col) {
+			return nil, fmt.Errorf("%w: (%d,%d) is not within %dx%d", ErrCellOutOfRange, update.Row, update.Col, s.Rows, s.Cols)
 		}
-		
-		// Convert bit to boolean (0 -> false, 1 -> true)
-		result[key] = bitValue == 1
-	}
-	
-	return result, nil
-}
-
-// UpdateCheckboxState updates the state of a checkbox in Redis
-func (s *CheckboxService) UpdateCheckboxState(row uint32, column uint32, value bool) error {
-	ctx := context.Background()
-	
-	// Create a key in the format "(row,column)"
-	key := fmt.Sprintf("states:(%d,%d)", row, column)
-	
-    // Convert boolean to bit (0 for false, 1 for true)
-    bitValue := 0
-    if value {
-        bitValue = 1
-    }
-    // err:= s.RedisClient.HSet(ctx, "states", key, bitValue)
-	
-    // Set the bit in Redis - using offset 0 since we're storing a single bit per key
-    err := s.RedisClient.SetBit(ctx, key, 0, bitValue).Err()
-    if err != nil {
-		return fmt.Errorf("failed to set bit in Redis: %w", err)
-	}
-
-    // Publish a message to notify about the update
-    message := fmt.Sprintf("(%d,%d):%t", row, column, value)
-    err = s.RedisClient.Publish(ctx, "checkbox_updates", message).Err()
-    if err != nil {
-        return fmt.Errorf("failed to publish update notification: %w", err)
-    }
+	}
+	changes, err := s.Store.SetCells(attribute(ctx), updates)
+	s.checkGone(err)
+	return changes, err
+}
 
-	return nil
+// checkGone reports a write that found the grid deleted
+func (s *CheckboxService) checkGone(err error) {
+	if s.gone != nil && errors.Is(err, store.ErrGridNotFound) {
+		s.gone()
+	}
+}
+
+// ResetGrid clears every checkbox on the board, notifies subscribers and
+// returns the grid's new version
+func (s *CheckboxService) ResetGrid(ctx context.Context) (int64, error) {
+	version, err := s.Store.Reset(ctx)
+	s.checkGone(err)
+	return version, err
+}
+
+// ChangesSince returns the checkbox changes made after version. ok is false
+// when they are no longer all available and a full snapshot is needed.
+func (s *CheckboxService) ChangesSince(ctx context.Context, version int64) (changes []store.Change, ok bool, err error) {
+	return s.Store.ChangesSince(ctx, version)
+}
+
+// Subscribe returns a channel receiving every checkbox change until ctx is
+// cancelled or the grid is deleted
+func (s *CheckboxService) Subscribe(ctx context.Context) (<-chan store.Change, error) {
+	return s.Store.Subscribe(ctx)
 }
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/usman-007/checkbox-backend/config"
+	"github.com/usman-007/checkbox-backend/internal/models"
+	"github.com/usman-007/checkbox-backend/internal/store"
+)
+
+// DefaultGridID is the grid served by the routes that do not name a grid
+const DefaultGridID = "default"
+
+// MaxGridCells caps the size of grids created through the API, the same as
+// the default grid's
+const MaxGridCells = config.MaxGridCells
+
+var (
+	// ErrInvalidGridID is returned for grid IDs that are not 1-64 letters, digits, '-' or '_'
+	ErrInvalidGridID = errors.New("grid ID must be 1-64 letters, digits, '-' or '_'")
+
+	// ErrInvalidGridSize is returned for grid dimensions that are not positive
+	// or exceed MaxGridCells
+	ErrInvalidGridSize = fmt.Errorf("grid must have positive dimensions and at most %d cells", MaxGridCells)
+
+	// ErrDefaultGrid is returned when trying to delete the default grid
+	ErrDefaultGrid = errors.New("the default grid cannot be deleted")
+
+	// ErrTooManyGrids is returned when creating a grid would exceed the
+	// GridService's maximum number of grids
+	ErrTooManyGrids = errors.New("too many grids")
+)
+
+var gridIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// GridService manages the set of grids and hands out a CheckboxService per grid
+type GridService struct {
+	backend  store.Backend
+	maxGrids int
+
+	// createMutex serializes creations so concurrent ones cannot together
+	// exceed maxGrids. Instances sharing a backend may still overshoot it
+	// slightly.
+	createMutex sync.Mutex
+
+	mutex sync.Mutex
+	grids map[string]*CheckboxService
+}
+
+// NewGridService creates a GridService storing grids in backend that lets
+// at most maxGrids grids, the default one included, be created
+func NewGridService(backend store.Backend, maxGrids int) *GridService {
+	return &GridService{
+		backend:  backend,
+		maxGrids: maxGrids,
+		grids:    make(map[string]*CheckboxService),
+	}
+}
+
+// InitGrid opens the grid with the given ID, creating it if needed. It is
+// used at startup for the default grid.
+func (s *GridService) InitGrid(ctx context.Context, id string, rows, cols int) (*CheckboxService, error) {
+	gridStore, err := s.backend.InitGrid(ctx, id, rows, cols)
+	if err != nil {
+		return nil, err
+	}
+	return s.cache(id, gridStore), nil
+}
+
+// CreateGrid creates a new empty grid, or returns ErrTooManyGrids if there
+// are already as many as allowed
+func (s *GridService) CreateGrid(ctx context.Context, id string, rows, cols int) (*CheckboxService, error) {
+	if !gridIDPattern.MatchString(id) {
+		return nil, ErrInvalidGridID
+	}
+	// Divide rather than multiply so huge dimensions cannot overflow past the check
+	if rows <= 0 || cols <= 0 || rows > MaxGridCells/cols {
+		return nil, ErrInvalidGridSize
+	}
+
+	s.createMutex.Lock()
+	defer s.createMutex.Unlock()
+	ids, err := s.backend.ListGrids(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) >= s.maxGrids {
+		return nil, fmt.Errorf("%w: at most %d may exist", ErrTooManyGrids, s.maxGrids)
+	}
+
+	gridStore, err := s.backend.CreateGrid(ctx, id, rows, cols)
+	if err != nil {
+		return nil, err
+	}
+	return s.cache(id, gridStore), nil
+}
+
+// GetGrid returns the service for an existing grid, or store.ErrGridNotFound
+func (s *GridService) GetGrid(ctx context.Context, id string) (*CheckboxService, error) {
+	s.mutex.Lock()
+	grid, ok := s.grids[id]
+	s.mutex.Unlock()
+	if ok {
+		return grid, nil
+	}
+
+	// The grid may have been created by another instance
+	if !gridIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("%w: %q", store.ErrGridNotFound, id)
+	}
+	gridStore, err := s.backend.OpenGrid(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.cache(id, gridStore), nil
+}
+
+// ListGrids returns the metadata of every grid, sorted by ID
+func (s *GridService) ListGrids(ctx context.Context) ([]models.Grid, error) {
+	ids, err := s.backend.ListGrids(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+
+	grids := make([]models.Grid, 0, len(ids))
+	for _, id := range ids {
+		grid, err := s.GetGrid(ctx, id)
+		if errors.Is(err, store.ErrGridNotFound) {
+			// Deleted between listing and opening
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		grids = append(grids, grid.GetGrid())
+	}
+	return grids, nil
+}
+
+// DeleteGrid removes a grid and its state
+func (s *GridService) DeleteGrid(ctx context.Context, id string) error {
+	if id == DefaultGridID {
+		return ErrDefaultGrid
+	}
+
+	s.mutex.Lock()
+	delete(s.grids, id)
+	s.mutex.Unlock()
+
+	return s.backend.DeleteGrid(ctx, id)
+}
+
+// WatchDeletions makes the service forget grids as soon as any instance
+// deletes them, until ctx is cancelled. Without it, a grid deleted by another
+// instance would go on being served from the cache until a write found it
+// gone.
+func (s *GridService) WatchDeletions(ctx context.Context) error {
+	ids, err := s.backend.WatchDeletions(ctx)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for id := range ids {
+			s.mutex.Lock()
+			delete(s.grids, id)
+			s.mutex.Unlock()
+		}
+	}()
+	return nil
+}
+
+// cache remembers the service for a grid so later lookups skip the backend
+func (s *GridService) cache(id string, gridStore store.GridStore) *CheckboxService {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if grid, ok := s.grids[id]; ok {
+		return grid
+	}
+	grid := NewCheckboxService(id, gridStore)
+	// Forget grids deleted by another instance, so later requests look them up again
+	grid.gone = func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if s.grids[id] == grid {
+			delete(s.grids, id)
+		}
+	}
+	s.grids[id] = grid
+	return grid
+}
@@ -0,0 +1,109 @@
+package session
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned for a session token that is malformed, has a
+// bad signature or has expired
+var ErrInvalidToken = errors.New("invalid session token")
+
+// idBytes is the number of random bytes in a session ID
+const idBytes = 16
+
+// Session is an anonymous player identity
+type Session struct {
+	ID        string
+	ExpiresAt time.Time
+}
+
+// Actor is how changes made in the session are attributed
+func (s Session) Actor() string {
+	return "session:" + s.ID
+}
+
+// Manager issues and verifies session tokens. A token is
+// "<id>.<expiry>.<signature>": the session ID in hex, its expiry in Unix
+// seconds and an HMAC-SHA256 of the two, base64url-encoded without padding.
+type Manager struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewManager creates a manager signing tokens with secret that are valid for ttl
+func NewManager(secret []byte, ttl time.Duration) *Manager {
+	return &Manager{
+		secret: secret,
+		ttl:    ttl,
+	}
+}
+
+// TTL returns how long issued tokens are valid
+func (m *Manager) TTL() time.Duration {
+	return m.ttl
+}
+
+// Issue creates a new session and returns it with its token
+func (m *Manager) Issue() (Session, string, error) {
+	id := make([]byte, idBytes)
+	if _, err := rand.Read(id); err != nil {
+		return Session{}, "", fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	s := Session{
+		ID:        hex.EncodeToString(id),
+		ExpiresAt: time.Now().Add(m.ttl).Truncate(time.Second),
+	}
+	payload := s.ID + "." + strconv.FormatInt(s.ExpiresAt.Unix(), 10)
+	return s, payload + "." + m.sign(payload), nil
+}
+
+// Verify checks a token's signature and expiry and returns its session
+func (m *Manager) Verify(token string) (Session, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || len(parts[0]) != 2*idBytes {
+		return Session{}, ErrInvalidToken
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(m.sign(payload))) {
+		return Session{}, ErrInvalidToken
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return Session{}, ErrInvalidToken
+	}
+	s := Session{ID: parts[0], ExpiresAt: time.Unix(expiry, 0)}
+	if !time.Now().Before(s.ExpiresAt) {
+		return Session{}, fmt.Errorf("%w: expired at %s", ErrInvalidToken, s.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	return s, nil
+}
+
+func (m *Manager) sign(payload string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// contextKey is the context key of the request's session
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying s
+func NewContext(ctx context.Context, s Session) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the session carried by ctx, if any
+func FromContext(ctx context.Context) (Session, bool) {
+	s, ok := ctx.Value(contextKey{}).(Session)
+	return s, ok
+}
@@ -0,0 +1,60 @@
+package session
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManagerVerify(t *testing.T) {
+	manager := NewManager([]byte("secret"), time.Hour)
+	issued, token, err := manager.Issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+
+	// sign makes a token for id expiring at expiry, signed by manager
+	sign := func(manager *Manager, id string, expiry time.Time) string {
+		payload := id + "." + strconv.FormatInt(expiry.Unix(), 10)
+		return payload + "." + manager.sign(payload)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "issued token", token: token},
+		{name: "empty", token: "", wantErr: true},
+		{name: "missing signature", token: parts[0] + "." + parts[1], wantErr: true},
+		{name: "extra segment", token: token + ".x", wantErr: true},
+		{name: "short ID", token: sign(manager, "abcd", time.Now().Add(time.Hour)), wantErr: true},
+		{name: "tampered ID", token: strings.Repeat("0", 2*idBytes) + "." + parts[1] + "." + parts[2], wantErr: true},
+		{name: "extended expiry", token: parts[0] + "." + strconv.FormatInt(issued.ExpiresAt.Unix()+3600, 10) + "." + parts[2], wantErr: true},
+		{name: "bad signature", token: parts[0] + "." + parts[1] + ".AAAA", wantErr: true},
+		{name: "other secret", token: sign(NewManager([]byte("other"), time.Hour), parts[0], time.Now().Add(time.Hour)), wantErr: true},
+		{name: "non-numeric expiry", token: parts[0] + ".soon." + manager.sign(parts[0]+".soon"), wantErr: true},
+		{name: "expired", token: sign(manager, parts[0], time.Now().Add(-time.Second)), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := manager.Verify(tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Errorf("Verify error = %v, want ErrInvalidToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if s.ID != issued.ID || !s.ExpiresAt.Equal(issued.ExpiresAt) {
+				t.Errorf("Verify = %+v, want %+v", s, issued)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/usman-007/checkbox-backend/internal/models"
+)
+
+// subscriberBuffer is how many changes a subscriber may fall behind before
+// further changes are dropped for it
+const subscriberBuffer = 256
+
+// MemoryBackend keeps every grid in process memory. It is safe for
+// concurrent use and lets the server run without Redis, but its state is lost
+// on restart and is not shared between instances.
+type MemoryBackend struct {
+	logSize  int
+	cellMeta bool
+
+	mutex sync.Mutex
+	grids map[string]*MemoryStore
+}
+
+// NewMemoryBackend creates a backend with no grids. Each grid remembers its
+// last logSize changes for ChangesSince, and keeps cell metadata if cellMeta
+// is set.
+func NewMemoryBackend(logSize int, cellMeta bool) *MemoryBackend {
+	return &MemoryBackend{
+		logSize:  logSize,
+		cellMeta: cellMeta,
+		grids:    make(map[string]*MemoryStore),
+	}
+}
+
+// InitGrid opens the grid with the given ID, creating it if it does not exist
+func (b *MemoryBackend) InitGrid(ctx context.Context, id string, rows, cols int) (GridStore, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if grid, ok := b.grids[id]; ok {
+		if grid.rows != rows || grid.cols != cols {
+			return nil, fmt.Errorf("%w: stored grid %q is %dx%d, requested grid is %dx%d",
+				ErrDimensionMismatch, id, grid.rows, grid.cols, rows, cols)
+		}
+		return grid, nil
+	}
+
+	grid := NewMemoryStore(rows, cols, b.logSize, b.cellMeta)
+	b.grids[id] = grid
+	return grid, nil
+}
+
+// CreateGrid creates a new grid, returning ErrGridExists if the ID is taken
+func (b *MemoryBackend) CreateGrid(ctx context.Context, id string, rows, cols int) (GridStore, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.grids[id]; ok {
+		return nil, fmt.Errorf("%w: %q", ErrGridExists, id)
+	}
+
+	grid := NewMemoryStore(rows, cols, b.logSize, b.cellMeta)
+	b.grids[id] = grid
+	return grid, nil
+}
+
+// OpenGrid opens an existing grid, returning ErrGridNotFound if there is none
+func (b *MemoryBackend) OpenGrid(ctx context.Context, id string) (GridStore, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	grid, ok := b.grids[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrGridNotFound, id)
+	}
+	return grid, nil
+}
+
+// ListGrids returns the IDs of every grid in sorted order
+func (b *MemoryBackend) ListGrids(ctx context.Context) ([]string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	ids := make([]string, 0, len(b.grids))
+	for id := range b.grids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// DeleteGrid removes a grid and closes its subscriptions
+func (b *MemoryBackend) DeleteGrid(ctx context.Context, id string) error {
+	b.mutex.Lock()
+	grid, ok := b.grids[id]
+	delete(b.grids, id)
+	b.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrGridNotFound, id)
+	}
+	grid.closeSubscribers()
+	return nil
+}
+
+// WatchDeletions implements Backend. Grids kept in memory can only be
+// deleted through this instance, which needs no telling, so nothing is sent.
+func (b *MemoryBackend) WatchDeletions(ctx context.Context) (<-chan string, error) {
+	ids := make(chan string)
+	go func() {
+		<-ctx.Done()
+		close(ids)
+	}()
+	return ids, nil
+}
+
+// MemoryStore is a GridStore for a single grid kept in process memory
+type MemoryStore struct {
+	rows     int
+	cols     int
+	logSize  int
+	cellMeta bool
+
+	mutex   sync.RWMutex
+	bitmap  []byte
+	version int64
+	log     []Change
+	// meta holds the metadata of changed cells by index, if cellMeta is set
+	meta        map[int]CellMeta
+	subscribers map[chan Change]struct{}
+}
+
+// NewMemoryStore creates an empty in-memory store for a rows x cols grid
+// that remembers its last logSize changes and, if cellMeta is set, who
+// changed each cell and when
+func NewMemoryStore(rows, cols, logSize int, cellMeta bool) *MemoryStore {
+	return &MemoryStore{
+		rows:        rows,
+		cols:        cols,
+		logSize:     logSize,
+		cellMeta:    cellMeta,
+		bitmap:      make([]byte, (rows*cols+7)/8),
+		meta:        make(map[int]CellMeta),
+		subscribers: make(map[chan Change]struct{}),
+	}
+}
+
+// Dimensions returns the number of rows and columns in the grid
+func (s *MemoryStore) Dimensions() (rows, cols int) {
+	return s.rows, s.cols
+}
+
+// GetCell returns the state of a single cell and its metadata
+func (s *MemoryStore) GetCell(ctx context.Context, row, col int) (bool, *CellMeta, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	index := row*s.cols + col
+	var meta *CellMeta
+	if m, ok := s.meta[index]; ok {
+		meta = &m
+	}
+	return Bit(s.bitmap, index), meta, nil
+}
+
+// SetCell stores the state of a single cell and notifies subscribers
+func (s *MemoryStore) SetCell(ctx context.Context, row, col int, value bool) (Change, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	change := s.applyLocked(CellUpdate{Row: row, Col: col, Value: value}, newCellMeta(ctx, s.cellMeta))
+	s.notifyLocked(change)
+	return change, nil
+}
+
+// ToggleCell flips a single cell and notifies subscribers
+func (s *MemoryStore) ToggleCell(ctx context.Context, row, col int) (Change, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	value := !Bit(s.bitmap, row*s.cols+col)
+	change := s.applyLocked(CellUpdate{Row: row, Col: col, Value: value}, newCellMeta(ctx, s.cellMeta))
+	s.notifyLocked(change)
+	return change, nil
+}
+
+// CompareAndSetCell sets a single cell if it holds expected and notifies subscribers
+func (s *MemoryStore) CompareAndSetCell(ctx context.Context, row, col int, expected, value bool) (Change, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if current := Bit(s.bitmap, row*s.cols+col); current != expected {
+		return Change{Row: row, Col: col, Value: current, Version: s.version}, false, nil
+	}
+	change := s.applyLocked(CellUpdate{Row: row, Col: col, Value: value}, newCellMeta(ctx, s.cellMeta))
+	s.notifyLocked(change)
+	return change, true, nil
+}
+
+// SetCells stores several cells under one lock and notifies subscribers of them
+func (s *MemoryStore) SetCells(ctx context.Context, updates []CellUpdate) ([]Change, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	meta := newCellMeta(ctx, s.cellMeta)
+	changes := make([]Change, len(updates))
+	for i, update := range updates {
+		changes[i] = s.applyLocked(update, meta)
+	}
+	s.notifyLocked(changes...)
+	return changes, nil
+}
+
+// applyLocked stores one cell and its metadata, if any, bumps the version
+// and logs the change. s.mutex must be held for writing.
+func (s *MemoryStore) applyLocked(update CellUpdate, meta *CellMeta) Change {
+	index := update.Row*s.cols + update.Col
+	setBit(s.bitmap, index, update.Value)
+	if meta != nil {
+		s.meta[index] = *meta
+	}
+	s.version++
+
+	change := Change{Row: update.Row, Col: update.Col, Value: update.Value, Version: s.version, Meta: meta}
+	s.log = append(s.log, change)
+	if len(s.log) > s.logSize {
+		s.log = s.log[len(s.log)-s.logSize:]
+	}
+	return change
+}
+
+// notifyLocked sends changes to every subscriber without blocking. s.mutex
+// must be held.
+func (s *MemoryStore) notifyLocked(changes ...Change) {
+	for ch := range s.subscribers {
+		for _, change := range changes {
+			select {
+			case ch <- change:
+			default:
+				log.Printf("Dropping change %+v for slow in-memory subscriber", change)
+			}
+		}
+	}
+}
+
+// Snapshot returns a copy of the cells of region
+func (s *MemoryStore) Snapshot(ctx context.Context, region models.Region) (Snapshot, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var bitmap []byte
+	if region == (models.Region{RowEnd: s.rows, ColEnd: s.cols}) {
+		bitmap = append([]byte(nil), s.bitmap...)
+	} else {
+		bitmap = CropBitmap(s.bitmap, s.cols, region)
+	}
+	return Snapshot{
+		Bitmap:  bitmap,
+		Version: s.version,
+	}, nil
+}
+
+// Reset clears every cell of the grid and notifies subscribers
+func (s *MemoryStore) Reset(ctx context.Context) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	clear(s.bitmap)
+	clear(s.meta)
+	s.version++
+	s.log = nil
+	s.notifyLocked(Change{Version: s.version, Reset: true})
+	return s.version, nil
+}
+
+// ChangesSince returns the logged changes made after version
+func (s *MemoryStore) ChangesSince(ctx context.Context, version int64) ([]Change, bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// Versions in the log are consecutive, so the start is found by offset
+	var changes []Change
+	if len(s.log) > 0 {
+		start := version + 1 - s.log[0].Version
+		if start >= 0 && start < int64(len(s.log)) {
+			changes = append(changes, s.log[start:]...)
+		}
+	}
+	return changes, coversSince(changes, version, s.version), nil
+}
+
+// Subscribe returns a channel receiving every change made to the grid
+func (s *MemoryStore) Subscribe(ctx context.Context) (<-chan Change, error) {
+	ch := make(chan Change, subscriberBuffer)
+
+	s.mutex.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.unsubscribe(ch)
+	}()
+
+	return ch, nil
+}
+
+// unsubscribe removes and closes a subscriber channel if it is still registered
+func (s *MemoryStore) unsubscribe(ch chan Change) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.subscribers[ch]; ok {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// closeSubscribers closes every subscriber channel, used when the grid is deleted
+func (s *MemoryStore) closeSubscribers() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for ch := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
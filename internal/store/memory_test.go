@@ -0,0 +1,176 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/usman-007/checkbox-backend/internal/models"
+)
+
+// versions returns the versions of changes
+func versions(changes []Change) []int64 {
+	out := make([]int64, len(changes))
+	for i, c := range changes {
+		out[i] = c.Version
+	}
+	return out
+}
+
+func TestMemoryStoreChangesSince(t *testing.T) {
+	ctx := context.Background()
+	// The log keeps the last 3 of 5 changes, versions 3 to 5
+	s := NewMemoryStore(4, 4, 3, false)
+	for i := 0; i < 5; i++ {
+		if _, err := s.SetCell(ctx, i%4, i/4, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		since    int64
+		want     []int64
+		complete bool
+	}{
+		{name: "before the log", since: 0, want: nil, complete: false},
+		{name: "just before the log", since: 1, want: nil, complete: false},
+		{name: "start of the log", since: 2, want: []int64{3, 4, 5}, complete: true},
+		{name: "middle of the log", since: 3, want: []int64{4, 5}, complete: true},
+		{name: "last change", since: 4, want: []int64{5}, complete: true},
+		{name: "current version", since: 5, want: nil, complete: true},
+		{name: "future version", since: 6, want: nil, complete: false},
+		{name: "negative version", since: -1, want: nil, complete: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, complete, err := s.ChangesSince(ctx, tt.since)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := versions(changes); !slices.Equal(got, tt.want) {
+				t.Errorf("ChangesSince(%d) versions = %v, want %v", tt.since, got, tt.want)
+			}
+			if complete != tt.complete {
+				t.Errorf("ChangesSince(%d) complete = %v, want %v", tt.since, complete, tt.complete)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreCompareAndSetCell(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		initial   bool
+		expected  bool
+		value     bool
+		applied   bool
+		wantValue bool
+	}{
+		{name: "match sets", initial: false, expected: false, value: true, applied: true, wantValue: true},
+		{name: "match clears", initial: true, expected: true, value: false, applied: true, wantValue: false},
+		{name: "match same value", initial: true, expected: true, value: true, applied: true, wantValue: true},
+		{name: "mismatch on unset cell", initial: false, expected: true, value: false, applied: false, wantValue: false},
+		{name: "mismatch on set cell", initial: true, expected: false, value: false, applied: false, wantValue: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemoryStore(2, 2, 10, false)
+			if _, err := s.SetCell(ctx, 1, 1, tt.initial); err != nil {
+				t.Fatal(err)
+			}
+
+			change, applied, err := s.CompareAndSetCell(ctx, 1, 1, tt.expected, tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if applied != tt.applied {
+				t.Errorf("applied = %v, want %v", applied, tt.applied)
+			}
+			if change.Value != tt.wantValue {
+				t.Errorf("change value = %v, want %v", change.Value, tt.wantValue)
+			}
+			// A mismatch reports the current version and leaves the cell alone
+			wantVersion := int64(1)
+			if tt.applied {
+				wantVersion = 2
+			}
+			if change.Version != wantVersion {
+				t.Errorf("change version = %d, want %d", change.Version, wantVersion)
+			}
+			value, _, err := s.GetCell(ctx, 1, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != tt.wantValue {
+				t.Errorf("stored value = %v, want %v", value, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreReset(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(3, 3, 10, true)
+	changes, err := s.SetCells(ctx, []CellUpdate{{Row: 0, Col: 0, Value: true}, {Row: 2, Col: 1, Value: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := changes[len(changes)-1].Version
+
+	updates, err := s.Subscribe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := s.Reset(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != before+1 {
+		t.Errorf("Reset version = %d, want %d", version, before+1)
+	}
+
+	if change := <-updates; !change.Reset || change.Version != version {
+		t.Errorf("subscriber got %+v, want a reset at version %d", change, version)
+	}
+
+	snapshot, err := s.Snapshot(ctx, models.Region{RowEnd: 3, ColEnd: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Version != version {
+		t.Errorf("snapshot version = %d, want %d", snapshot.Version, version)
+	}
+	for i, b := range snapshot.Bitmap {
+		if b != 0 {
+			t.Errorf("snapshot byte %d = %#x after reset, want 0", i, b)
+		}
+	}
+	if _, meta, _ := s.GetCell(ctx, 0, 0); meta != nil {
+		t.Errorf("cell metadata = %+v after reset, want none", meta)
+	}
+
+	// Changes from before the reset cannot be replayed, but the reset
+	// version itself is up to date
+	tests := []struct {
+		since    int64
+		complete bool
+	}{
+		{since: 0, complete: false},
+		{since: before, complete: false},
+		{since: version, complete: true},
+	}
+	for _, tt := range tests {
+		changes, complete, err := s.ChangesSince(ctx, tt.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 0 || complete != tt.complete {
+			t.Errorf("ChangesSince(%d) = %v, %v, want no changes, %v", tt.since, changes, complete, tt.complete)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/usman-007/checkbox-backend/internal/models"
+	"github.com/usman-007/checkbox-backend/internal/redis"
+)
+
+// gridDeletedMessage is published on a grid's updates channel when the grid is
+// deleted so subscribers on every instance stop listening
+const gridDeletedMessage = "deleted"
+
+// RedisBackend keeps grids in Redis, with changes fanned out to every app
+// instance through Redis Pub/Sub
+type RedisBackend struct {
+	client   *redis.Client
+	logSize  int
+	cellMeta bool
+}
+
+// NewRedisBackend creates a backend storing grids through client. Each grid
+// keeps roughly its last logSize changes in a Redis stream for ChangesSince,
+// and keeps cell metadata if cellMeta is set.
+func NewRedisBackend(client *redis.Client, logSize int, cellMeta bool) *RedisBackend {
+	return &RedisBackend{
+		client:   client,
+		logSize:  logSize,
+		cellMeta: cellMeta,
+	}
+}
+
+// InitGrid opens the grid with the given ID, creating it if it does not exist
+func (b *RedisBackend) InitGrid(ctx context.Context, id string, rows, cols int) (GridStore, error) {
+	if err := b.client.InitializeGridState(ctx, id, rows, cols); err != nil {
+		return nil, err
+	}
+	return NewRedisStore(b.client, id, rows, cols, b.logSize, b.cellMeta), nil
+}
+
+// CreateGrid creates a new grid, returning ErrGridExists if the ID is taken
+func (b *RedisBackend) CreateGrid(ctx context.Context, id string, rows, cols int) (GridStore, error) {
+	added, err := b.client.SAdd(ctx, redis.GridsKey, id).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to register grid %q: %w", id, err)
+	}
+	if added == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrGridExists, id)
+	}
+	if _, err := b.client.ReplaceGridState(ctx, id, rows, cols); err != nil {
+		return nil, err
+	}
+	return NewRedisStore(b.client, id, rows, cols, b.logSize, b.cellMeta), nil
+}
+
+// OpenGrid opens an existing grid, returning ErrGridNotFound if there is none
+func (b *RedisBackend) OpenGrid(ctx context.Context, id string) (GridStore, error) {
+	rows, cols, ok, err := b.client.GetGridDimensions(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrGridNotFound, id)
+	}
+	return NewRedisStore(b.client, id, rows, cols, b.logSize, b.cellMeta), nil
+}
+
+// ListGrids returns the IDs of every grid
+func (b *RedisBackend) ListGrids(ctx context.Context) ([]string, error) {
+	return b.client.ListGrids(ctx)
+}
+
+// DeleteGrid removes a grid and tells every instance about it: the grid's
+// subscribers through its updates channel, and WatchDeletions callers
+// through the grid deletions channel
+func (b *RedisBackend) DeleteGrid(ctx context.Context, id string) error {
+	existed, err := b.client.DeleteGrid(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !existed {
+		return fmt.Errorf("%w: %q", ErrGridNotFound, id)
+	}
+	if err := b.client.Publish(ctx, redis.GridUpdatesChannel(id), gridDeletedMessage).Err(); err != nil {
+		return fmt.Errorf("failed to publish deletion of grid %q: %w", id, err)
+	}
+	if err := b.client.Publish(ctx, redis.GridDeletionsChannel, id).Err(); err != nil {
+		return fmt.Errorf("failed to publish deletion of grid %q: %w", id, err)
+	}
+	return nil
+}
+
+// WatchDeletions listens on the grid deletions channel and returns the IDs
+// of the grids deleted
+func (b *RedisBackend) WatchDeletions(ctx context.Context) (<-chan string, error) {
+	pubsub := b.client.Subscribe(ctx, redis.GridDeletionsChannel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to Redis channel '%s': %w", redis.GridDeletionsChannel, err)
+	}
+
+	ids := make(chan string, subscriberBuffer)
+	go func() {
+		defer close(ids)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case ids <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ids, nil
+}
+
+// RedisStore is a GridStore for a grid kept in Redis as chunk bitmaps
+type RedisStore struct {
+	client   *redis.Client
+	id       string
+	rows     int
+	cols     int
+	logSize  int
+	cellMeta bool
+}
+
+// NewRedisStore creates a store for the rows x cols grid with the given ID
+// that logs roughly its last logSize changes and, if cellMeta is set, keeps
+// who changed each cell and when
+func NewRedisStore(client *redis.Client, id string, rows, cols, logSize int, cellMeta bool) *RedisStore {
+	return &RedisStore{
+		client:   client,
+		id:       id,
+		rows:     rows,
+		cols:     cols,
+		logSize:  logSize,
+		cellMeta: cellMeta,
+	}
+}
+
+// Dimensions returns the number of rows and columns in the grid
+func (s *RedisStore) Dimensions() (rows, cols int) {
+	return s.rows, s.cols
+}
+
+// GetCell returns the state of a single cell and its metadata
+func (s *RedisStore) GetCell(ctx context.Context, row, col int) (bool, *CellMeta, error) {
+	value, data, err := s.client.GetGridCell(ctx, s.id, row, col)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to get bit from Redis: %w", err)
+	}
+	meta, err := decodeCellMeta(data)
+	if err != nil {
+		return false, nil, fmt.Errorf("invalid cell metadata %q: %w", data, err)
+	}
+	return value, meta, nil
+}
+
+// SetCell stores the state of a single cell and publishes the change
+func (s *RedisStore) SetCell(ctx context.Context, row, col int, value bool) (Change, error) {
+	meta := newCellMeta(ctx, s.cellMeta)
+	data, err := encodeCellMeta(meta)
+	if err != nil {
+		return Change{}, err
+	}
+	version, err := s.client.SetGridCell(ctx, s.id, row, col, value, data, s.logSize)
+	if err != nil {
+		return Change{}, fmt.Errorf("failed to set bit in Redis: %w", err)
+	}
+	return Change{Row: row, Col: col, Value: value, Version: version, Meta: meta}, nil
+}
+
+// ToggleCell flips a single cell and publishes the change
+func (s *RedisStore) ToggleCell(ctx context.Context, row, col int) (Change, error) {
+	meta := newCellMeta(ctx, s.cellMeta)
+	data, err := encodeCellMeta(meta)
+	if err != nil {
+		return Change{}, err
+	}
+	value, version, err := s.client.ToggleGridCell(ctx, s.id, row, col, data, s.logSize)
+	if err != nil {
+		return Change{}, fmt.Errorf("failed to toggle bit in Redis: %w", err)
+	}
+	return Change{Row: row, Col: col, Value: value, Version: version, Meta: meta}, nil
+}
+
+// CompareAndSetCell sets a single cell if it holds expected and publishes the change
+func (s *RedisStore) CompareAndSetCell(ctx context.Context, row, col int, expected, value bool) (Change, bool, error) {
+	meta := newCellMeta(ctx, s.cellMeta)
+	data, err := encodeCellMeta(meta)
+	if err != nil {
+		return Change{}, false, err
+	}
+	ok, current, version, err := s.client.CompareAndSetGridCell(ctx, s.id, row, col, expected, value, data, s.logSize)
+	if err != nil {
+		return Change{}, false, fmt.Errorf("failed to compare and set bit in Redis: %w", err)
+	}
+	if !ok {
+		return Change{Row: row, Col: col, Value: current, Version: version}, false, nil
+	}
+	return Change{Row: row, Col: col, Value: current, Version: version, Meta: meta}, true, nil
+}
+
+// SetCells stores several cells with one script call, which publishes them
+// as a single message
+func (s *RedisStore) SetCells(ctx context.Context, updates []CellUpdate) ([]Change, error) {
+	cells := make([]redis.GridCell, len(updates))
+	for i, update := range updates {
+		cells[i] = redis.GridCell{Row: update.Row, Col: update.Col, Value: update.Value}
+	}
+	meta := newCellMeta(ctx, s.cellMeta)
+	data, err := encodeCellMeta(meta)
+	if err != nil {
+		return nil, err
+	}
+	last, err := s.client.SetGridCells(ctx, s.id, cells, data, s.logSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set bits in Redis: %w", err)
+	}
+
+	// The updates got consecutive versions ending at last
+	changes := make([]Change, len(updates))
+	for i, update := range updates {
+		version := last - int64(len(updates)-1-i)
+		changes[i] = Change{Row: update.Row, Col: update.Col, Value: update.Value, Version: version, Meta: meta}
+	}
+	return changes, nil
+}
+
+// Snapshot reads the chunks that region overlaps, together with the grid
+// version, in a single transaction and copies the region's cells out of them
+func (s *RedisStore) Snapshot(ctx context.Context, region models.Region) (Snapshot, error) {
+	first := redis.GridChunk{Row: region.RowStart / redis.GridChunkSize, Col: region.ColStart / redis.GridChunkSize}
+	last := redis.GridChunk{Row: (region.RowEnd - 1) / redis.GridChunkSize, Col: (region.ColEnd - 1) / redis.GridChunkSize}
+	var chunks []redis.GridChunk
+	for r := first.Row; r <= last.Row; r++ {
+		for c := first.Col; c <= last.Col; c++ {
+			chunks = append(chunks, redis.GridChunk{Row: r, Col: c})
+		}
+	}
+
+	bitmaps, version, err := s.client.GetGridChunks(ctx, s.id, chunks)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to get grid state from Redis: %w", err)
+	}
+
+	width := region.Cols()
+	bitmap := make([]byte, (region.Rows()*width+7)/8)
+	for i, chunk := range chunks {
+		if bitmaps[i] == nil {
+			continue
+		}
+		// The part of the region this chunk covers
+		rowStart := max(region.RowStart, chunk.Row*redis.GridChunkSize)
+		rowEnd := min(region.RowEnd, (chunk.Row+1)*redis.GridChunkSize)
+		colStart := max(region.ColStart, chunk.Col*redis.GridChunkSize)
+		colEnd := min(region.ColEnd, (chunk.Col+1)*redis.GridChunkSize)
+		for r := rowStart; r < rowEnd; r++ {
+			for c := colStart; c < colEnd; c++ {
+				_, _, bit := redis.GridChunkBit(r, c)
+				if Bit(bitmaps[i], bit) {
+					setBit(bitmap, (r-region.RowStart)*width+c-region.ColStart, true)
+				}
+			}
+		}
+	}
+	return Snapshot{Bitmap: bitmap, Version: version}, nil
+}
+
+// Reset replaces the stored grid with a zeroed one and publishes the reset
+func (s *RedisStore) Reset(ctx context.Context) (int64, error) {
+	version, err := s.client.ResetGridState(ctx, s.id, s.rows, s.cols)
+	if err != nil {
+		return 0, err
+	}
+	payload, err := json.Marshal(Change{Version: version, Reset: true})
+	if err != nil {
+		return 0, err
+	}
+	if err := s.client.Publish(ctx, redis.GridUpdatesChannel(s.id), payload).Err(); err != nil {
+		return 0, fmt.Errorf("failed to publish reset of grid %q: %w", s.id, err)
+	}
+	return version, nil
+}
+
+// ChangesSince reads the changes after version from the grid's changes stream
+func (s *RedisStore) ChangesSince(ctx context.Context, version int64) ([]Change, bool, error) {
+	entries, current, err := s.client.GetGridChanges(ctx, s.id, version)
+	if err != nil {
+		return nil, false, err
+	}
+
+	changes := make([]Change, 0, len(entries))
+	for _, entry := range entries {
+		// IDs are "0-<version>"
+		var change Change
+		if _, err := fmt.Sscanf(entry.ID, "0-%d", &change.Version); err != nil {
+			return nil, false, fmt.Errorf("invalid change log entry ID %q: %w", entry.ID, err)
+		}
+		change.Row, _ = strconv.Atoi(fmt.Sprint(entry.Values["row"]))
+		change.Col, _ = strconv.Atoi(fmt.Sprint(entry.Values["col"]))
+		change.Value = fmt.Sprint(entry.Values["value"]) == "1"
+		if data, ok := entry.Values["meta"].(string); ok {
+			if change.Meta, err = decodeCellMeta(data); err != nil {
+				return nil, false, fmt.Errorf("invalid cell metadata in change log entry %q: %w", entry.ID, err)
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes, coversSince(changes, version, current), nil
+}
+
+// Subscribe listens on the grid's updates channel and returns the decoded changes
+func (s *RedisStore) Subscribe(ctx context.Context) (<-chan Change, error) {
+	channel := redis.GridUpdatesChannel(s.id)
+	pubsub := s.client.Subscribe(ctx, channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to Redis channel '%s': %w", channel, err)
+	}
+
+	changes := make(chan Change, subscriberBuffer)
+	go func() {
+		defer close(changes)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok || msg.Payload == gridDeletedMessage {
+					return
+				}
+				received, err := decodeChanges(msg.Payload)
+				if err != nil {
+					log.Printf("Ignoring malformed update on '%s': %q", channel, msg.Payload)
+					continue
+				}
+				for _, change := range received {
+					select {
+					case changes <- change:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+// decodeChanges parses an updates channel message: one change as a JSON
+// object, or a batch of them as an array
+func decodeChanges(payload string) ([]Change, error) {
+	if strings.HasPrefix(payload, "[") {
+		var changes []Change
+		err := json.Unmarshal([]byte(payload), &changes)
+		return changes, err
+	}
+	var change Change
+	if err := json.Unmarshal([]byte(payload), &change); err != nil {
+		return nil, err
+	}
+	return []Change{change}, nil
+}
@@ -0,0 +1,228 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/usman-007/checkbox-backend/internal/models"
+	"github.com/usman-007/checkbox-backend/internal/redis"
+)
+
+var (
+	// ErrGridNotFound is returned when a grid ID does not exist, including
+	// when another instance deleted it
+	ErrGridNotFound = redis.ErrGridNotFound
+
+	// ErrGridExists is returned when creating a grid whose ID is already taken
+	ErrGridExists = errors.New("grid already exists")
+
+	// ErrDimensionMismatch is returned when a stored grid was created with
+	// different dimensions than the ones requested
+	ErrDimensionMismatch = redis.ErrGridDimensionMismatch
+)
+
+// Change describes a single cell update
+type Change struct {
+	Row   int  `json:"row"`
+	Col   int  `json:"column"`
+	Value bool `json:"value"`
+	// Version is the grid version this change produced. Versions increase by
+	// one with every change to a grid.
+	Version int64 `json:"version"`
+	// Meta records when and by whom the change was made. It is only set by
+	// stores that keep cell metadata.
+	Meta *CellMeta `json:"meta,omitempty"`
+	// Reset marks the whole grid being cleared at Version rather than a
+	// change to one cell; Row, Col, Value and Meta are unset
+	Reset bool `json:"reset,omitempty"`
+}
+
+// CellMeta records when and by whom a cell was last changed
+type CellMeta struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	// Actor is the session or user that made the change, if known
+	Actor string `json:"actor,omitempty"`
+}
+
+// actorKey is the context key of the actor changes are attributed to
+type actorKey struct{}
+
+// WithActor returns a copy of ctx whose cell changes are attributed to actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor that changes made with ctx are attributed to,
+// or "" if there is none
+func ActorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// newCellMeta returns the metadata of a change made now with ctx, or nil for
+// stores that do not keep cell metadata
+func newCellMeta(ctx context.Context, enabled bool) *CellMeta {
+	if !enabled {
+		return nil
+	}
+	return &CellMeta{UpdatedAt: time.Now().UTC(), Actor: ActorFrom(ctx)}
+}
+
+// encodeCellMeta encodes cell metadata as a JSON object, or "" for none
+func encodeCellMeta(meta *CellMeta) (string, error) {
+	if meta == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(meta)
+	return string(data), err
+}
+
+// decodeCellMeta undoes encodeCellMeta
+func decodeCellMeta(data string) (*CellMeta, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var meta CellMeta
+	if err := json.Unmarshal([]byte(data), &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// CellUpdate is a value to store in one cell
+type CellUpdate struct {
+	Row   int
+	Col   int
+	Value bool
+}
+
+// Snapshot is the state of a region of a grid at a given version
+type Snapshot struct {
+	// Bitmap holds the region's cells row by row in Redis bit order: cell
+	// (row, col) is bit (row-RowStart)*width+(col-ColStart), where width is
+	// the region's width. For the whole grid that is bit row*cols+col.
+	Bitmap  []byte
+	Version int64
+}
+
+// GridStore persists the state of one grid of checkboxes and notifies
+// subscribers whenever a cell changes
+type GridStore interface {
+	// Dimensions returns the number of rows and columns in the grid
+	Dimensions() (rows, cols int)
+
+	// GetCell returns the state of a single cell and its metadata, which is
+	// nil if the store keeps none or the cell was never changed
+	GetCell(ctx context.Context, row, col int) (value bool, meta *CellMeta, err error)
+
+	// SetCell stores the state of a single cell, notifies subscribers and
+	// returns the resulting change, including the grid's new version. Stores
+	// that keep cell metadata attribute the change to ActorFrom(ctx).
+	SetCell(ctx context.Context, row, col int, value bool) (Change, error)
+
+	// ToggleCell flips a single cell, notifies subscribers and returns the
+	// resulting change, which holds the cell's new value
+	ToggleCell(ctx context.Context, row, col int) (Change, error)
+
+	// CompareAndSetCell sets a single cell like SetCell, but only if it
+	// currently holds expected. Otherwise ok is false, nothing is written and
+	// the returned change holds the cell's current value and the grid's
+	// current version.
+	CompareAndSetCell(ctx context.Context, row, col int, expected, value bool) (change Change, ok bool, err error)
+
+	// SetCells stores several cells in one atomic step and notifies
+	// subscribers of them together. The changes are returned in the order
+	// of updates, with consecutive versions.
+	SetCells(ctx context.Context, updates []CellUpdate) ([]Change, error)
+
+	// Snapshot returns the cells of region, which must lie within the grid,
+	// and the version they reflect
+	Snapshot(ctx context.Context, region models.Region) (Snapshot, error)
+
+	// Reset clears every cell of the grid, bumps its version, notifies
+	// subscribers with a Reset change and returns the new version
+	Reset(ctx context.Context) (version int64, err error)
+
+	// ChangesSince returns the changes made after the given version, oldest
+	// first. Only a bounded number of recent changes is kept, so ok is false
+	// when the log no longer covers every version since then and the caller
+	// must fall back to a Snapshot.
+	ChangesSince(ctx context.Context, version int64) (changes []Change, ok bool, err error)
+
+	// Subscribe returns a channel receiving every change made to the grid.
+	// The channel is closed once ctx is cancelled or the grid is deleted.
+	Subscribe(ctx context.Context) (<-chan Change, error)
+}
+
+// Backend creates, looks up and deletes the grids kept in one storage system
+type Backend interface {
+	// InitGrid opens the grid with the given ID, creating it if it does not
+	// exist. ErrDimensionMismatch is returned if it exists with other dimensions.
+	InitGrid(ctx context.Context, id string, rows, cols int) (GridStore, error)
+
+	// CreateGrid creates a new grid, returning ErrGridExists if the ID is taken
+	CreateGrid(ctx context.Context, id string, rows, cols int) (GridStore, error)
+
+	// OpenGrid opens an existing grid, returning ErrGridNotFound if there is none
+	OpenGrid(ctx context.Context, id string) (GridStore, error)
+
+	// ListGrids returns the IDs of every grid
+	ListGrids(ctx context.Context) ([]string, error)
+
+	// DeleteGrid removes a grid and closes its subscriptions, returning
+	// ErrGridNotFound if there is none
+	DeleteGrid(ctx context.Context, id string) error
+
+	// WatchDeletions returns a channel receiving the ID of every grid
+	// deleted by any instance sharing the backend, so they can forget it.
+	// The channel is closed once ctx is cancelled.
+	WatchDeletions(ctx context.Context) (<-chan string, error)
+}
+
+// coversSince reports whether changes, the logged changes after since, lead
+// without gaps from since to current
+func coversSince(changes []Change, since, current int64) bool {
+	if since > current {
+		return false
+	}
+	if since == current {
+		return true
+	}
+	return len(changes) > 0 && changes[0].Version == since+1 && changes[len(changes)-1].Version == current
+}
+
+// Bit reports whether bit i is set in a bitmap. Bits are numbered from the
+// most significant bit of the first byte, as Redis does, and bits past the
+// end of the bitmap read as zero.
+func Bit(bitmap []byte, i int) bool {
+	if i/8 >= len(bitmap) {
+		return false
+	}
+	return bitmap[i/8]&(0x80>>(i%8)) != 0
+}
+
+// setBit sets or clears bit i in bitmap, which must be large enough to hold it
+func setBit(bitmap []byte, i int, value bool) {
+	if value {
+		bitmap[i/8] |= 0x80 >> (i % 8)
+	} else {
+		bitmap[i/8] &^= 0x80 >> (i % 8)
+	}
+}
+
+// CropBitmap returns the cells of region from the bitmap of a grid with cols
+// columns, as a bitmap of the region's own width
+func CropBitmap(bitmap []byte, cols int, region models.Region) []byte {
+	width := region.Cols()
+	cropped := make([]byte, (region.Rows()*width+7)/8)
+	for r := region.RowStart; r < region.RowEnd; r++ {
+		for c := region.ColStart; c < region.ColEnd; c++ {
+			if Bit(bitmap, r*cols+c) {
+				setBit(cropped, (r-region.RowStart)*width+c-region.ColStart, true)
+			}
+		}
+	}
+	return cropped
+}
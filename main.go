@@ -2,13 +2,22 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"log"
 
 	"github.com/gin-gonic/gin"
 	"github.com/usman-007/checkbox-backend/api/middleware"
 	"github.com/usman-007/checkbox-backend/api/routes"
 	"github.com/usman-007/checkbox-backend/config"
+	"github.com/usman-007/checkbox-backend/internal/auth"
+	"github.com/usman-007/checkbox-backend/internal/origin"
+	"github.com/usman-007/checkbox-backend/internal/ratelimit"
 	"github.com/usman-007/checkbox-backend/internal/redis"
+	"github.com/usman-007/checkbox-backend/internal/services"
+	"github.com/usman-007/checkbox-backend/internal/session"
+	"github.com/usman-007/checkbox-backend/internal/store"
 )
 
 func main() {
@@ -18,26 +27,90 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Initialize Redis client
-	redisClient, err := redis.NewClient(&cfg.Redis)
-	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
-	}
-	defer redisClient.Close()
-
-		// Define grid dimensions
-	gridRows := 20
-	gridCols := 20
+	// Grid dimensions
+	gridRows := cfg.Grid.Rows
+	gridCols := cfg.Grid.Cols
 
 	// Create a context
 	ctx := context.Background()
 
-	// Initialize the grid state
-	err = redisClient.InitializeGridState(ctx, gridRows, gridCols)
+	// Initialize grid storage
+	var backend store.Backend
+	var redisClient *redis.Client
+	switch cfg.StoreBackend {
+	case "memory":
+		log.Println("Using in-memory grid store; state will not survive a restart")
+		backend = store.NewMemoryBackend(cfg.Grid.ChangeLogSize, cfg.Grid.CellMetadata)
+	default:
+		redisClient, err = redis.NewClient(&cfg.Redis)
+		if err != nil {
+			log.Fatalf("Failed to connect to Redis: %v", err)
+		}
+		defer redisClient.Close()
+
+		// Move any state from older key layouts into the default grid
+		if _, err := redisClient.MigrateLegacyGridState(ctx, services.DefaultGridID, gridRows, gridCols); err != nil {
+			log.Println("Error migrating legacy grid state:", err)
+		}
+		// Split grids stored as one bitmap into chunks
+		if _, err := redisClient.MigrateFlatGridStates(ctx); err != nil {
+			log.Println("Error splitting grid state into chunks:", err)
+		}
+
+		// Keep whatever board is already stored unless a reset was explicitly requested
+		if cfg.Grid.ResetOnStart {
+			if _, err := redisClient.ReplaceGridState(ctx, services.DefaultGridID, gridRows, gridCols); err != nil {
+				log.Println("Error resetting grid state:", err)
+			}
+		}
+
+		backend = store.NewRedisBackend(redisClient, cfg.Grid.ChangeLogSize, cfg.Grid.CellMetadata)
+	}
+
+	// Initialize the default grid
+	gridService := services.NewGridService(backend, cfg.Grid.MaxCount)
+	_, err = gridService.InitGrid(ctx, services.DefaultGridID, gridRows, gridCols)
+	if errors.Is(err, store.ErrDimensionMismatch) {
+		log.Fatalf("%v (set GRID_RESET_ON_START=true to replace the stored grid)", err)
+	}
+	if err != nil {
+		log.Fatalf("Failed to initialize grid state: %v", err)
+	}
+	// Forget grids other instances delete
+	if err := gridService.WatchDeletions(ctx); err != nil {
+		log.Fatalf("Failed to watch for deleted grids: %v", err)
+	}
+
+	// Sign session tokens with the configured secret, or a random one
+	sessionSecret := []byte(cfg.Session.Secret)
+	if len(sessionSecret) == 0 {
+		log.Println("SESSION_SECRET is not set; using a random secret, so sessions will not survive a restart")
+		sessionSecret = make([]byte, 32)
+		if _, err := rand.Read(sessionSecret); err != nil {
+			log.Fatalf("Failed to generate session secret: %v", err)
+		}
+	}
+	sessions := session.NewManager(sessionSecret, cfg.Session.TTL)
+
+	// Accept the configured JWT secret and API keys
+	authenticator, err := newAuthenticator(cfg.Auth)
 	if err != nil {
-		log.Println("Error initializing grid state:", err)
+		log.Fatalf("Invalid auth configuration: %v", err)
 	}
 
+	// Share rate limits through Redis when there is one
+	var limiter ratelimit.Limiter
+	if redisClient != nil {
+		limiter = ratelimit.NewRedisLimiter(redisClient, cfg.RateLimit.Rate, cfg.RateLimit.Burst)
+	} else {
+		limiter = ratelimit.NewMemoryLimiter(cfg.RateLimit.Rate, cfg.RateLimit.Burst)
+	}
+
+	// Browser origins allowed to call the API and open WebSockets
+	allowlist, err := origin.NewAllowlist(cfg.AllowedOrigins)
+	if err != nil {
+		log.Fatalf("Invalid ALLOWED_ORIGINS: %v", err)
+	}
 
 	// Set Gin mode
 	if cfg.Environment == "production" {
@@ -45,17 +118,41 @@ func main() {
 	}
 
 	// Initialize router
-	router := gin.Default()
+	// gin.Default's logger would print credentials passed in query strings
+	router := gin.New()
+	// Only configured proxies may set the client IP that anonymous rate
+	// limits are keyed by; Gin otherwise believes any X-Forwarded-For
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	router.Use(middleware.AccessLog(), gin.Recovery())
 
 	// Apply global middleware
 	router.Use(middleware.Logger())
-	router.Use(middleware.CORS())
+	router.Use(middleware.CORS(allowlist))
 
 	// Register routes
-	routes.Setup(router, redisClient)
+	routes.Setup(router, cfg, gridService, sessions, authenticator, limiter, allowlist, redisClient)
 
 	// Start server
 	if err := router.Run(cfg.ServerAddress); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
-} 
\ No newline at end of file
+}
+
+// newAuthenticator builds the authenticator described by cfg
+func newAuthenticator(cfg config.AuthConfig) (*auth.Authenticator, error) {
+	anonymous, err := auth.ParseRole(cfg.AnonymousRole)
+	if err != nil {
+		return nil, fmt.Errorf("AUTH_ANONYMOUS_ROLE: %w", err)
+	}
+	apiKeys := make([]auth.APIKey, len(cfg.APIKeys))
+	for i, key := range cfg.APIKeys {
+		role, err := auth.ParseRole(key.Role)
+		if err != nil {
+			return nil, fmt.Errorf("API key %q: %w", key.Name, err)
+		}
+		apiKeys[i] = auth.APIKey{Name: key.Name, Role: role, Key: key.Key}
+	}
+	return auth.NewAuthenticator([]byte(cfg.JWTSecret), apiKeys, anonymous), nil
+}
@@ -0,0 +1,393 @@
+// Package binproto encodes and decodes the compact binary WebSocket protocol
+// for checkbox grids. It has no dependencies on the server so clients and
+// tools can share it.
+//
+// Every message starts with a one-byte MessageType. Integers are big-endian.
+//
+//	Snapshot        type | flags u8 | version u64 | rows u32 | cols u32 | bitmap
+//	RegionSnapshot  type | flags u8 | version u64 | rows u32 | cols u32 |
+//	                row_start u32 | row_end u32 | col_start u32 | col_end u32 | bitmap
+//	Delta           type | version u64 | count u32 | count x (index u32 | value u8)
+//	Ack             type | version u64 | req_id str16 [| value u8]
+//	Error           type | req_id str16 | code str8 | message str16 [| value u8]
+//	Notice          type | version u64 | code str8 | message str16
+//
+// strN is an N-bit length followed by that many bytes. The bitmap holds one
+// bit per cell, cell (row, col) at bit row*cols+col counting from the most
+// significant bit of the first byte; when FlagDeflate is set it is
+// compressed with raw DEFLATE (RFC 1951). A RegionSnapshot covers only rows
+// row_start up to row_end and columns col_start up to col_end (ends
+// exclusive) and numbers its bits within the region, cell (row, col) at
+// (row-row_start)*(col_end-col_start)+col-col_start. In a Delta, index is
+// row*cols+col and version is that of the last change. Request IDs are the
+// raw JSON text of the req_id the client sent, e.g. `"17"` or `17`. An Ack
+// for a request that changed a checkbox ends with its new value, and an Error
+// for a conditional set that did not apply ends with its current value.
+package binproto
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Subprotocol is the WebSocket subprotocol name for this format
+const Subprotocol = "checkboxes.v1.bin"
+
+// MessageType identifies a message
+type MessageType byte
+
+// Message types
+const (
+	TypeSnapshot MessageType = 1
+	TypeDelta    MessageType = 2
+	TypeAck      MessageType = 3
+	TypeError    MessageType = 4
+	TypeNotice   MessageType = 5
+
+	TypeRegionSnapshot MessageType = 6
+)
+
+// FlagDeflate marks a snapshot whose bitmap is DEFLATE-compressed
+const FlagDeflate byte = 1 << 0
+
+// CompressThreshold is the bitmap size from which EncodeSnapshot compresses
+const CompressThreshold = 1024
+
+// ErrShortMessage is returned when a message ends before its declared contents
+var ErrShortMessage = errors.New("binproto: message too short")
+
+// Snapshot is the whole grid at a version
+type Snapshot struct {
+	Version int64
+	Rows    int
+	Cols    int
+	Bitmap  []byte
+}
+
+// Region is a rectangle of cells; the ends are exclusive
+type Region struct {
+	RowStart int
+	RowEnd   int
+	ColStart int
+	ColEnd   int
+}
+
+// RegionSnapshot is one region of the grid at a version
+type RegionSnapshot struct {
+	Version int64
+	Rows    int
+	Cols    int
+	Region  Region
+	Bitmap  []byte
+}
+
+// Cell is one changed cell in a Delta
+type Cell struct {
+	Index uint32
+	Value bool
+}
+
+// Delta is a batch of cell changes
+type Delta struct {
+	Version int64
+	Cells   []Cell
+}
+
+// Ack confirms a client request. Value is the checkbox's new value, if the
+// request changed one.
+type Ack struct {
+	Version int64
+	ReqID   []byte
+	Value   *bool
+}
+
+// Error reports a failed client request. Value is the checkbox's current
+// value, if a conditional set did not apply.
+type Error struct {
+	ReqID   []byte
+	Code    string
+	Message string
+	Value   *bool
+}
+
+// Notice is an informational server message
+type Notice struct {
+	Version int64
+	Code    string
+	Message string
+}
+
+// EncodeSnapshot encodes a snapshot, compressing bitmaps of at least
+// CompressThreshold bytes
+func EncodeSnapshot(s Snapshot) ([]byte, error) {
+	flags, bitmap, err := compressBitmap(s.Bitmap)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, 18+len(bitmap))
+	out = append(out, byte(TypeSnapshot), flags)
+	out = binary.BigEndian.AppendUint64(out, uint64(s.Version))
+	out = binary.BigEndian.AppendUint32(out, uint32(s.Rows))
+	out = binary.BigEndian.AppendUint32(out, uint32(s.Cols))
+	return append(out, bitmap...), nil
+}
+
+// EncodeRegionSnapshot encodes a region snapshot, compressing bitmaps of at
+// least CompressThreshold bytes
+func EncodeRegionSnapshot(s RegionSnapshot) ([]byte, error) {
+	flags, bitmap, err := compressBitmap(s.Bitmap)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, 34+len(bitmap))
+	out = append(out, byte(TypeRegionSnapshot), flags)
+	out = binary.BigEndian.AppendUint64(out, uint64(s.Version))
+	for _, v := range []int{s.Rows, s.Cols, s.Region.RowStart, s.Region.RowEnd, s.Region.ColStart, s.Region.ColEnd} {
+		out = binary.BigEndian.AppendUint32(out, uint32(v))
+	}
+	return append(out, bitmap...), nil
+}
+
+// compressBitmap deflates bitmaps of at least CompressThreshold bytes,
+// returning the snapshot flags that describe the result
+func compressBitmap(bitmap []byte) (byte, []byte, error) {
+	if len(bitmap) < CompressThreshold {
+		return 0, bitmap, nil
+	}
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := w.Write(bitmap); err != nil {
+		return 0, nil, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, nil, err
+	}
+	return FlagDeflate, buf.Bytes(), nil
+}
+
+// EncodeDelta encodes a batch of cell changes
+func EncodeDelta(d Delta) []byte {
+	out := make([]byte, 0, 13+5*len(d.Cells))
+	out = append(out, byte(TypeDelta))
+	out = binary.BigEndian.AppendUint64(out, uint64(d.Version))
+	out = binary.BigEndian.AppendUint32(out, uint32(len(d.Cells)))
+	for _, cell := range d.Cells {
+		out = binary.BigEndian.AppendUint32(out, cell.Index)
+		if cell.Value {
+			out = append(out, 1)
+		} else {
+			out = append(out, 0)
+		}
+	}
+	return out
+}
+
+// EncodeAck encodes an ack
+func EncodeAck(a Ack) []byte {
+	out := []byte{byte(TypeAck)}
+	out = binary.BigEndian.AppendUint64(out, uint64(a.Version))
+	out = appendStr16(out, a.ReqID)
+	return appendValue(out, a.Value)
+}
+
+// EncodeError encodes an error
+func EncodeError(e Error) []byte {
+	out := []byte{byte(TypeError)}
+	out = appendStr16(out, e.ReqID)
+	out = appendStr8(out, []byte(e.Code))
+	out = appendStr16(out, []byte(e.Message))
+	return appendValue(out, e.Value)
+}
+
+// EncodeNotice encodes a notice
+func EncodeNotice(n Notice) []byte {
+	out := []byte{byte(TypeNotice)}
+	out = binary.BigEndian.AppendUint64(out, uint64(n.Version))
+	out = appendStr8(out, []byte(n.Code))
+	return appendStr16(out, []byte(n.Message))
+}
+
+// Decode decodes a message into a *Snapshot, *RegionSnapshot, *Delta, *Ack,
+// *Error or *Notice. Snapshot bitmaps are returned decompressed.
+func Decode(data []byte) (any, error) {
+	if len(data) == 0 {
+		return nil, ErrShortMessage
+	}
+	r := &reader{data: data[1:]}
+
+	switch MessageType(data[0]) {
+	case TypeSnapshot:
+		flags := r.u8()
+		s := &Snapshot{
+			Version: int64(r.u64()),
+			Rows:    int(r.u32()),
+			Cols:    int(r.u32()),
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		var err error
+		s.Bitmap, err = decompressBitmap(flags, r.data)
+		return s, err
+
+	case TypeRegionSnapshot:
+		flags := r.u8()
+		s := &RegionSnapshot{
+			Version: int64(r.u64()),
+			Rows:    int(r.u32()),
+			Cols:    int(r.u32()),
+			Region: Region{
+				RowStart: int(r.u32()),
+				RowEnd:   int(r.u32()),
+				ColStart: int(r.u32()),
+				ColEnd:   int(r.u32()),
+			},
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		var err error
+		s.Bitmap, err = decompressBitmap(flags, r.data)
+		return s, err
+
+	case TypeDelta:
+		d := &Delta{Version: int64(r.u64())}
+		count := r.u32()
+		if r.err == nil && uint64(count)*5 > uint64(len(r.data)) {
+			return nil, ErrShortMessage
+		}
+		d.Cells = make([]Cell, 0, count)
+		for i := uint32(0); i < count && r.err == nil; i++ {
+			d.Cells = append(d.Cells, Cell{Index: r.u32(), Value: r.u8() != 0})
+		}
+		return d, r.err
+
+	case TypeAck:
+		a := &Ack{Version: int64(r.u64())}
+		a.ReqID = r.str16()
+		a.Value = r.optionalValue()
+		return a, r.err
+
+	case TypeError:
+		e := &Error{ReqID: r.str16()}
+		e.Code = string(r.str8())
+		e.Message = string(r.str16())
+		e.Value = r.optionalValue()
+		return e, r.err
+
+	case TypeNotice:
+		n := &Notice{Version: int64(r.u64())}
+		n.Code = string(r.str8())
+		n.Message = string(r.str16())
+		return n, r.err
+
+	default:
+		return nil, fmt.Errorf("binproto: unknown message type %d", data[0])
+	}
+}
+
+// decompressBitmap undoes compressBitmap
+func decompressBitmap(flags byte, data []byte) ([]byte, error) {
+	if flags&FlagDeflate == 0 {
+		return data, nil
+	}
+	bitmap, err := io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("binproto: invalid compressed bitmap: %w", err)
+	}
+	return bitmap, nil
+}
+
+// appendValue appends an optional trailing checkbox value
+func appendValue(out []byte, value *bool) []byte {
+	switch {
+	case value == nil:
+		return out
+	case *value:
+		return append(out, 1)
+	default:
+		return append(out, 0)
+	}
+}
+
+func appendStr8(out, s []byte) []byte {
+	if len(s) > 0xff {
+		s = s[:0xff]
+	}
+	return append(append(out, byte(len(s))), s...)
+}
+
+func appendStr16(out, s []byte) []byte {
+	if len(s) > 0xffff {
+		s = s[:0xffff]
+	}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(s)))
+	return append(out, s...)
+}
+
+// reader consumes fields from a message, remembering the first error
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = ErrShortMessage
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) u8() byte {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) u32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) u64() uint64 {
+	if b := r.take(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *reader) str8() []byte {
+	return r.take(int(r.u8()))
+}
+
+func (r *reader) str16() []byte {
+	if b := r.take(2); b != nil {
+		return r.take(int(binary.BigEndian.Uint16(b)))
+	}
+	return nil
+}
+
+// optionalValue reads a trailing checkbox value, if there is one
+func (r *reader) optionalValue() *bool {
+	if r.err != nil || len(r.data) == 0 {
+		return nil
+	}
+	value := r.u8() != 0
+	return &value
+}
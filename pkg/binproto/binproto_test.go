@@ -0,0 +1,203 @@
+package binproto
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func boolPtr(v bool) *bool {
+	return &v
+}
+
+func TestRoundTrip(t *testing.T) {
+	large := bytes.Repeat([]byte{0xa5, 0x00, 0xff, 0x0f}, CompressThreshold/4)
+
+	tests := []struct {
+		name    string
+		encode  func() ([]byte, error)
+		want    any
+		deflate bool
+	}{
+		{
+			name: "snapshot",
+			encode: func() ([]byte, error) {
+				return EncodeSnapshot(Snapshot{Version: 42, Rows: 3, Cols: 5, Bitmap: []byte{0xff, 0x80}})
+			},
+			want: &Snapshot{Version: 42, Rows: 3, Cols: 5, Bitmap: []byte{0xff, 0x80}},
+		},
+		{
+			name: "compressed snapshot",
+			encode: func() ([]byte, error) {
+				return EncodeSnapshot(Snapshot{Version: 7, Rows: 64, Cols: 128, Bitmap: large})
+			},
+			want:    &Snapshot{Version: 7, Rows: 64, Cols: 128, Bitmap: large},
+			deflate: true,
+		},
+		{
+			name: "region snapshot",
+			encode: func() ([]byte, error) {
+				return EncodeRegionSnapshot(RegionSnapshot{
+					Version: 9, Rows: 100, Cols: 200,
+					Region: Region{RowStart: 10, RowEnd: 12, ColStart: 20, ColEnd: 24},
+					Bitmap: []byte{0x5a},
+				})
+			},
+			want: &RegionSnapshot{
+				Version: 9, Rows: 100, Cols: 200,
+				Region: Region{RowStart: 10, RowEnd: 12, ColStart: 20, ColEnd: 24},
+				Bitmap: []byte{0x5a},
+			},
+		},
+		{
+			name: "compressed region snapshot",
+			encode: func() ([]byte, error) {
+				return EncodeRegionSnapshot(RegionSnapshot{
+					Version: 1, Rows: 1000, Cols: 1000,
+					Region: Region{RowEnd: 64, ColEnd: 128},
+					Bitmap: large,
+				})
+			},
+			want: &RegionSnapshot{
+				Version: 1, Rows: 1000, Cols: 1000,
+				Region: Region{RowEnd: 64, ColEnd: 128},
+				Bitmap: large,
+			},
+			deflate: true,
+		},
+		{
+			name: "delta",
+			encode: func() ([]byte, error) {
+				return EncodeDelta(Delta{Version: 100, Cells: []Cell{{Index: 0, Value: true}, {Index: 1 << 31, Value: false}}}), nil
+			},
+			want: &Delta{Version: 100, Cells: []Cell{{Index: 0, Value: true}, {Index: 1 << 31, Value: false}}},
+		},
+		{
+			name: "empty delta",
+			encode: func() ([]byte, error) {
+				return EncodeDelta(Delta{Version: 3}), nil
+			},
+			want: &Delta{Version: 3, Cells: []Cell{}},
+		},
+		{
+			name: "ack",
+			encode: func() ([]byte, error) {
+				return EncodeAck(Ack{Version: 5, ReqID: []byte(`"17"`)}), nil
+			},
+			want: &Ack{Version: 5, ReqID: []byte(`"17"`)},
+		},
+		{
+			name: "ack with value",
+			encode: func() ([]byte, error) {
+				return EncodeAck(Ack{Version: 6, ReqID: []byte(`17`), Value: boolPtr(true)}), nil
+			},
+			want: &Ack{Version: 6, ReqID: []byte(`17`), Value: boolPtr(true)},
+		},
+		{
+			name: "error",
+			encode: func() ([]byte, error) {
+				return EncodeError(Error{ReqID: []byte(`"a"`), Code: "out_of_range", Message: "Row out of range"}), nil
+			},
+			want: &Error{ReqID: []byte(`"a"`), Code: "out_of_range", Message: "Row out of range"},
+		},
+		{
+			name: "error with value",
+			encode: func() ([]byte, error) {
+				return EncodeError(Error{ReqID: []byte(`1`), Code: "value_mismatch", Message: "mismatch", Value: boolPtr(false)}), nil
+			},
+			want: &Error{ReqID: []byte(`1`), Code: "value_mismatch", Message: "mismatch", Value: boolPtr(false)},
+		},
+		{
+			name: "notice",
+			encode: func() ([]byte, error) {
+				return EncodeNotice(Notice{Version: 12, Code: "grid_reset", Message: "grid reset"}), nil
+			},
+			want: &Notice{Version: 12, Code: "grid_reset", Message: "grid reset"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.encode()
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			if tt.deflate && data[1]&FlagDeflate == 0 {
+				t.Errorf("flags = %#x, want FlagDeflate set", data[1])
+			}
+			got, err := Decode(data)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	snapshot, err := EncodeSnapshot(Snapshot{Version: 1, Rows: 2, Cols: 2, Bitmap: []byte{0xf0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	regionSnapshot, err := EncodeRegionSnapshot(RegionSnapshot{Version: 1, Rows: 2, Cols: 2, Region: Region{RowEnd: 1, ColEnd: 2}, Bitmap: []byte{0xc0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every proper prefix of these messages cuts off a required field
+	tests := []struct {
+		name string
+		data []byte
+		// required is how many leading bytes are required; any trailing bitmap
+		// or optional value after them may be cut without an error
+		required int
+	}{
+		{name: "snapshot header", data: snapshot, required: 18},
+		{name: "region snapshot header", data: regionSnapshot, required: 34},
+		{name: "delta", data: EncodeDelta(Delta{Version: 2, Cells: []Cell{{Index: 1, Value: true}, {Index: 2}}})},
+		{name: "ack", data: EncodeAck(Ack{Version: 3, ReqID: []byte(`"x"`), Value: boolPtr(true)}), required: 14},
+		{name: "error", data: EncodeError(Error{ReqID: []byte(`4`), Code: "forbidden", Message: "no"})},
+		{name: "notice", data: EncodeNotice(Notice{Version: 5, Code: "resumed", Message: "resumed"})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			required := tt.required
+			if required == 0 {
+				required = len(tt.data)
+			}
+			for n := 0; n < required; n++ {
+				if _, err := Decode(tt.data[:n]); !errors.Is(err, ErrShortMessage) {
+					t.Errorf("Decode of the first %d of %d bytes: err = %v, want ErrShortMessage", n, len(tt.data), err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	compressed, err := EncodeSnapshot(Snapshot{Rows: 128, Cols: 128, Bitmap: make([]byte, 2*CompressThreshold)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "unknown type", data: []byte{0xee, 0, 0}},
+		{name: "truncated compressed bitmap", data: compressed[:len(compressed)-1]},
+		{name: "delta count past the end", data: append(EncodeDelta(Delta{Version: 1})[:9], 0, 0, 0, 2, 0, 0, 0, 1, 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if msg, err := Decode(tt.data); err == nil {
+				t.Errorf("Decode = %+v, want an error", msg)
+			}
+		})
+	}
+}